@@ -0,0 +1,168 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// findSources returns the gd sources matching the pattern. A pattern
+// is a directory, searched recursively if it ends in "/...". Sources
+// are non-test Go files that hold {md} comments or import "show".
+// Directories named vendor or testdata or starting with "." or "_"
+// are not searched.
+func findSources(pattern string) ([]string, error) {
+	root := pattern
+	recursive := strings.HasSuffix(pattern, "/...") || pattern == "..."
+	if recursive {
+		root = strings.TrimSuffix(strings.TrimSuffix(pattern, "..."), "/")
+		if root == "" {
+			root = "."
+		}
+	}
+
+	var sources []string
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if path == root {
+				return nil
+			}
+			name := fi.Name()
+			if !recursive || name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		ok, err := isSource(path)
+		if err != nil {
+			return err
+		}
+		if ok {
+			sources = append(sources, path)
+		}
+		return nil
+	})
+	return sources, err
+}
+
+// isSource returns whether the Go file at path is a gd source: it holds
+// {md} comments or imports the show package by its short or full path.
+func isSource(path string) (bool, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+	if err != nil {
+		// Files that do not parse are not our concern.
+		return false, nil
+	}
+	for _, imp := range f.Imports {
+		switch imp.Path.Value {
+		case `"show"`, `"github.com/kortschak/gd/show"`:
+			return true, nil
+		}
+	}
+	for _, c := range f.Comments {
+		for _, l := range c.List {
+			if strings.HasPrefix(l.Text, "/*{md}\n") {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// renderAll renders each of the sources to a file in the source's
+// directory, running up to jobs renderings in parallel. The file is
+// named by the output option for the source, or README.md if it is
+// not set. A summary of successes and failures is written to w. It
+// returns the number of failed renderings.
+func renderAll(w io.Writer, sources []string, jobs int, opts options) int {
+	if jobs < 1 {
+		jobs = 1
+	}
+	errs := make([]error, len(sources))
+	limit := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(i int, src string) {
+			defer func() {
+				<-limit
+				wg.Done()
+			}()
+			opts, err := opts.withConfig(src)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			name := opts.output
+			if name == "" {
+				name = "README.md"
+			}
+			errs[i] = renderTo(filepath.Join(filepath.Dir(src), name), src, opts)
+		}(i, src)
+	}
+	wg.Wait()
+
+	var failed int
+	for i, src := range sources {
+		if errs[i] != nil {
+			fmt.Fprintf(w, "FAIL\t%s: %v\n", src, errs[i])
+			failed++
+		} else {
+			fmt.Fprintf(w, "ok\t%s\n", src)
+		}
+	}
+	fmt.Fprintf(w, "%d rendered, %d failed\n", len(sources)-failed, failed)
+	return failed
+}
+
+// renderTo renders the gd source at path to the file target, writing
+// images to the target's directory. It returns an error after rendering
+// if an output expectation in the source does not hold or an example in
+// a Go test source fails.
+func renderTo(target, path string, opts options) error {
+	doc, err := load(path, nil, opts)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = opts.render(&buf, doc, target)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(target, buf.Bytes(), 0666)
+	if err != nil {
+		return err
+	}
+	if !opts.inline {
+		err = writeImages(filepath.Dir(target), doc)
+		if err != nil {
+			return err
+		}
+	}
+	if m := doc.mismatches(); len(m) != 0 {
+		return fmt.Errorf("output does not match expectation at line %d", m[0].start)
+	}
+	if len(doc.failed) != 0 {
+		return fmt.Errorf("output mismatch: %s", strings.Join(doc.failed, ", "))
+	}
+	return nil
+}
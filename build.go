@@ -0,0 +1,106 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// stringList is a flag.Value that collects the values of
+// a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// splitQuoted splits s into words as a POSIX shell does, so that
+// words holding spaces can be written in quotes, as in
+// -ldflags="-s -w". Single quotes preserve the text they enclose,
+// and in double quotes and unquoted text a backslash escapes the
+// following character. Other shell expansions are not performed.
+func splitQuoted(s string) ([]string, error) {
+	var (
+		words []string
+		word  strings.Builder
+		in    bool // in is whether a word has been started.
+		quote byte
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word.WriteByte(c)
+			}
+		case c == '\\':
+			if i+1 == len(s) {
+				return nil, fmt.Errorf("trailing backslash in %q", s)
+			}
+			i++
+			if quote == '"' && !strings.ContainsRune(`"\$`+"`", rune(s[i])) {
+				word.WriteByte(c)
+			}
+			word.WriteByte(s[i])
+			in = true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				word.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			in = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if in {
+				words = append(words, word.String())
+				word.Reset()
+				in = false
+			}
+		default:
+			word.WriteByte(c)
+			in = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in %q", quote, s)
+	}
+	if in {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// joinQuoted returns words joined into a string that splitQuoted
+// splits into words.
+func joinQuoted(words []string) string {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = shellQuote(w)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// buildArgs returns the go build arguments specified by o,
+// not including the output file and source.
+func (o options) buildArgs() []string {
+	tags := "gd"
+	if len(o.tags) != 0 {
+		tags += "," + strings.Join(o.tags, ",")
+	}
+	return append([]string{"build", "-tags", tags}, o.buildFlags...)
+}
@@ -0,0 +1,279 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kortschak/gd/internal/enc"
+)
+
+// buildEnv is the set of go env variables that affect the building
+// and running of a program.
+var buildEnv = []string{
+	"GOOS", "GOARCH", "GOVERSION", "GOROOT", "GOFLAGS", "GOEXPERIMENT",
+	"CGO_ENABLED", "GO386", "GOAMD64", "GOARM", "GOARM64", "GOMIPS",
+	"GOMIPS64", "GOPPC64", "GORISCV64", "GOWASM", "GOMOD", "GOWORK",
+}
+
+// toolEnv is the set of environment variables, other than those
+// starting with GO or CGO_, that affect the go command's building of
+// a program.
+var toolEnv = []string{"AR", "CC", "CXX", "FC", "GCCGO", "PKG_CONFIG"}
+
+// isGoEnv returns whether the environment variable in key=value form
+// affects the go command.
+func isGoEnv(kv string) bool {
+	key := kv
+	if i := strings.IndexByte(kv, '='); i >= 0 {
+		key = kv[:i]
+	}
+	if strings.HasPrefix(key, "GO") || strings.HasPrefix(key, "CGO_") {
+		return true
+	}
+	for _, k := range toolEnv {
+		if key == k {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultCacheDir returns the default result cache directory, or
+// the empty string if there is no user cache directory.
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gd")
+}
+
+// cacheKey returns the result cache key for running the document's
+// program src built with the go build arguments, build, and with the
+// additional environment variables, env. The key is derived from the
+// program source and path, its arguments, the build arguments, the
+// contents of declared input files and stdin, the other sources of the
+// package holding a test source, the working directory, the additional
+// environment and the environment variables that affect the go command,
+// the go env build inputs, the module files and the sources of the hook
+// packages. Other environment variables are not part of the key, so a
+// program that depends on them must be given them with -env.
+func (d *document) cacheKey(src []byte, build, env []string) (string, error) {
+	h := sha256.New()
+	field := func(name string, data []byte) {
+		fmt.Fprintf(h, "%s %d\n", name, len(data))
+		h.Write(data)
+	}
+
+	field("src", src)
+	path, err := filepath.Abs(d.path)
+	if err != nil {
+		return "", err
+	}
+	field("path", []byte(path))
+	for _, a := range d.args {
+		field("arg", []byte(a))
+	}
+	for _, a := range build {
+		field("build", []byte(a))
+	}
+	dir := filepath.Dir(d.path)
+	for _, in := range d.inputs {
+		data, err := ioutil.ReadFile(filepath.Join(dir, in))
+		if err != nil {
+			return "", err
+		}
+		field("input "+in, data)
+	}
+	if d.stdin != "" {
+		data, err := ioutil.ReadFile(d.stdin)
+		if err != nil {
+			return "", err
+		}
+		field("stdin", data)
+	}
+	if d.examples != nil {
+		files, err := d.packageSources()
+		if err != nil {
+			return "", err
+		}
+		for _, name := range files {
+			data, err := ioutil.ReadFile(name)
+			if err != nil {
+				return "", err
+			}
+			field("package "+filepath.Base(name), data)
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	field("wd", []byte(wd))
+	var environ []string
+	for _, e := range os.Environ() {
+		if isGoEnv(e) {
+			environ = append(environ, e)
+		}
+	}
+	sort.Strings(environ)
+	for _, e := range append(environ, env...) {
+		field("env", []byte(e))
+	}
+
+	cmd := exec.Command("go", append([]string{"env"}, buildEnv...)...)
+	if len(env) != 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	goenv, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	field("go env", goenv)
+	var gomod string
+	for i, v := range strings.Split(string(goenv), "\n") {
+		if i < len(buildEnv) && buildEnv[i] == "GOMOD" {
+			gomod = v
+			break
+		}
+	}
+	if gomod != "" && gomod != os.DevNull {
+		for _, name := range []string{gomod, gomod[:len(gomod)-len(".mod")] + ".sum"} {
+			data, err := ioutil.ReadFile(name)
+			if err != nil && !os.IsNotExist(err) {
+				return "", err
+			}
+			field(name, data)
+		}
+	}
+
+	hookFiles, err := hookSources()
+	if err != nil {
+		return "", err
+	}
+	for _, name := range hookFiles {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return "", err
+		}
+		field(name, data)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+const (
+	// cacheMaxAge is the time since their last use
+	// after which cached results are removed.
+	cacheMaxAge = 5 * 24 * time.Hour
+	// cacheTouchInterval is the time after which
+	// the use of a cached result is recorded.
+	cacheTouchInterval = time.Hour
+	// cacheTrimInterval is the time between
+	// removals of unused cached results.
+	cacheTrimInterval = 24 * time.Hour
+)
+
+// cacheLoad returns the events held in the result cache in dir for the
+// given key, and whether they were found. The modification time of a
+// cached result records when it was last used.
+func cacheLoad(dir, key string) (events []enc.Event, ok bool, err error) {
+	path := filepath.Join(dir, key+".json")
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	err = json.Unmarshal(data, &events)
+	if err != nil {
+		return nil, false, err
+	}
+	fi, err := os.Stat(path)
+	if err == nil && time.Since(fi.ModTime()) > cacheTouchInterval {
+		now := time.Now()
+		// Failing to record the use only
+		// risks the result being removed.
+		os.Chtimes(path, now, now)
+	}
+	return events, true, nil
+}
+
+// cacheTrim removes results from the result cache in dir that have not
+// been used for cacheMaxAge. The time of the last trim is recorded in
+// the cache, and the cache is trimmed at most once each
+// cacheTrimInterval.
+func cacheTrim(dir string) error {
+	now := time.Now()
+	stamp := filepath.Join(dir, "trim.txt")
+	fi, err := os.Stat(stamp)
+	if err == nil && now.Sub(fi.ModTime()) < cacheTrimInterval {
+		return nil
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		name := fi.Name()
+		if !strings.HasSuffix(name, ".json") && !strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		if now.Sub(fi.ModTime()) > cacheMaxAge {
+			err = os.Remove(filepath.Join(dir, name))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return ioutil.WriteFile(stamp, []byte(now.Format(time.RFC3339)+"\n"), 0666)
+}
+
+// cacheStore stores the events in the result cache in dir
+// under the given key.
+func cacheStore(dir, key string, events []enc.Event) error {
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
+	if events == nil {
+		events = []enc.Event{}
+	}
+	data, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	// Write via a temporary file so that concurrent
+	// readers never see a partial result.
+	tmp, err := ioutil.TempFile(dir, key+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, key+".json"))
+}
@@ -0,0 +1,88 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// check renders the document and compares the result with the file at
+// target and with the image files it references, writing a unified
+// diff or a description of each mismatch to w. It returns whether the
+// document and images are up to date.
+//
+// Code generation notices are not compared since they depend on how
+// gd was invoked.
+func check(w io.Writer, target string, doc *document, opts options) (ok bool, err error) {
+	var buf bytes.Buffer
+	err = opts.render(&buf, doc, target)
+	if err != nil {
+		return false, err
+	}
+	want, err := ioutil.ReadFile(target)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	ok = true
+	if err != nil {
+		fmt.Fprintf(w, "%s does not exist\n", target)
+		ok = false
+	} else {
+		diff := unifiedDiff(target, target+" (rendered)", stripNotice(string(want)), stripNotice(buf.String()))
+		if diff != "" {
+			fmt.Fprint(w, diff)
+			ok = false
+		}
+	}
+
+	if opts.inline {
+		return ok, nil
+	}
+	images, err := doc.images()
+	if err != nil {
+		return false, err
+	}
+	names := make([]string, 0, len(images))
+	for name := range images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	dir := imageBase(target)
+	for _, name := range names {
+		got, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		switch {
+		case os.IsNotExist(err):
+			fmt.Fprintf(w, "image %s does not exist\n", name)
+			ok = false
+		case err != nil:
+			return false, err
+		case !bytes.Equal(got, images[name]):
+			fmt.Fprintf(w, "image %s differs\n", name)
+			ok = false
+		}
+	}
+	return ok, nil
+}
+
+// stripNotice returns text without a leading code generation notice.
+// The notice may follow a front matter block.
+func stripNotice(text string) string {
+	text, front := stripFrontMatter(text)
+	if !strings.HasPrefix(text, "<!-- Code generated by ") {
+		return front + text
+	}
+	i := strings.Index(text, "\n")
+	if i < 0 {
+		return front
+	}
+	return front + text[i+1:]
+}
@@ -0,0 +1,214 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// configName is the name of gd configuration files.
+const configName = "gd.json"
+
+// config is a gd configuration file. Its settings apply to all sources
+// in the directory holding the file and below. Files holds settings for
+// individual sources keyed by their slash-separated path relative to
+// the configuration file. Output and stdin file names are relative to
+// the directory holding the source.
+type config struct {
+	settings
+	Files map[string]settings `json:"files"`
+}
+
+// settings holds configurable options. Fields that are not set in
+// a configuration file are left unaltered when the settings are
+// applied.
+type settings struct {
+	Output     *string           `json:"output"`
+	Images     *string           `json:"images"`
+	Format     *string           `json:"format"`
+	Layout     *string           `json:"layout"`
+	Inline     *bool             `json:"inline"`
+	Quote      *bool             `json:"quote"`
+	Notice     *bool             `json:"notice"`
+	Provenance *bool             `json:"provenance"`
+	Normalize  *string           `json:"normalize"`
+	Replace    []string          `json:"replace"`
+	Params     map[string]string `json:"params"`
+	Timeout    *string           `json:"timeout"`
+	MemLimit   *string           `json:"memlimit"`
+	OutLimit   *string           `json:"outlimit"`
+	Stdin      *string           `json:"stdin"`
+	Env        []string          `json:"env"`
+	Tags       []string          `json:"tags"`
+	BuildFlags []string          `json:"buildflags"`
+	Args       []string          `json:"args"`
+}
+
+// withConfig returns the options for rendering the source at path. The
+// options are those of o modified by the gd.json files in the source's
+// directory and its parents, with settings from files closer to the
+// source taking precedence and settings for the source file taking
+// precedence over directory settings. Options set on the command line
+// are not modified. Environment settings accumulate, with those on the
+// command line taking precedence.
+func (o options) withConfig(path string) (options, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return o, err
+	}
+
+	type located struct {
+		dir string
+		cfg config
+	}
+	var configs []located
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		name := filepath.Join(dir, configName)
+		data, err := ioutil.ReadFile(name)
+		switch {
+		case err == nil:
+			var cfg config
+			err = json.Unmarshal(data, &cfg)
+			if err != nil {
+				return o, fmt.Errorf("%s: %v", name, err)
+			}
+			// Prepend so that configs are ordered from
+			// the root towards the source.
+			configs = append([]located{{dir: dir, cfg: cfg}}, configs...)
+		case !os.IsNotExist(err):
+			return o, err
+		}
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	// Environment settings on the command line take
+	// precedence over those in configuration files.
+	env := o.env
+	o.env = nil
+	for _, c := range configs {
+		err = o.apply(c.cfg.settings, filepath.Join(c.dir, configName))
+		if err != nil {
+			return o, err
+		}
+	}
+	for _, c := range configs {
+		rel, err := filepath.Rel(c.dir, abs)
+		if err != nil {
+			return o, err
+		}
+		s, ok := c.cfg.Files[filepath.ToSlash(rel)]
+		if !ok {
+			continue
+		}
+		err = o.apply(s, filepath.Join(c.dir, configName))
+		if err != nil {
+			return o, err
+		}
+	}
+
+	// Record the build and environment settings so that
+	// the generated document records how it was made.
+	o.configArgs = nil
+	for _, e := range o.env {
+		o.configArgs = append(o.configArgs, "-env="+e)
+	}
+	o.env = append(o.env, env...)
+	if !o.flags["tags"] {
+		for _, t := range o.tags {
+			o.configArgs = append(o.configArgs, "-tags="+t)
+		}
+	}
+	if !o.flags["buildflags"] && len(o.buildFlags) != 0 {
+		o.configArgs = append(o.configArgs, "-buildflags="+joinQuoted(o.buildFlags))
+	}
+	return o, o.validate()
+}
+
+// apply applies the settings s from the configuration file
+// name to o, leaving options set on the command line unaltered.
+func (o *options) apply(s settings, name string) error {
+	configurable := func(flag string) bool { return !o.flags[flag] }
+	if s.Output != nil && configurable("o") {
+		o.output = *s.Output
+	}
+	if s.Images != nil && configurable("images") {
+		o.images = *s.Images
+	}
+	if s.Format != nil && configurable("format") {
+		o.format = *s.Format
+	}
+	if s.Layout != nil && configurable("layout") {
+		o.layout = *s.Layout
+	}
+	if s.Inline != nil && configurable("inline") {
+		o.inline = *s.Inline
+	}
+	if s.Quote != nil && configurable("quote") {
+		o.quote = *s.Quote
+	}
+	if s.Notice != nil && configurable("notice") {
+		o.notice = *s.Notice
+	}
+	if s.Provenance != nil && configurable("provenance") {
+		o.provenance = *s.Provenance
+	}
+	if s.Normalize != nil && configurable("normalize") {
+		o.normalize = *s.Normalize
+	}
+	o.replace = append(o.replace[:len(o.replace):len(o.replace)], s.Replace...)
+	if len(s.Params) != 0 {
+		set, err := parseParams(o.paramFlags)
+		if err != nil {
+			return err
+		}
+		params := make(map[string]string)
+		for k, v := range s.Params {
+			if _, ok := set[k]; !ok {
+				params[k] = v
+			}
+		}
+		*o = o.withParams(params)
+	}
+	if s.Timeout != nil && configurable("timeout") {
+		d, err := time.ParseDuration(*s.Timeout)
+		if err != nil {
+			return fmt.Errorf("%s: invalid timeout: %v", name, err)
+		}
+		o.timeout = d
+	}
+	if s.MemLimit != nil && configurable("memlimit") {
+		err := o.memlimit.Set(*s.MemLimit)
+		if err != nil {
+			return fmt.Errorf("%s: invalid memlimit: %v", name, err)
+		}
+	}
+	if s.OutLimit != nil && configurable("outlimit") {
+		err := o.outlimit.Set(*s.OutLimit)
+		if err != nil {
+			return fmt.Errorf("%s: invalid outlimit: %v", name, err)
+		}
+	}
+	if s.Stdin != nil && configurable("stdin") {
+		o.stdin = *s.Stdin
+	}
+	o.env = append(o.env[:len(o.env):len(o.env)], s.Env...)
+	if s.Tags != nil && configurable("tags") {
+		o.tags = s.Tags
+	}
+	if s.BuildFlags != nil && configurable("buildflags") {
+		o.buildFlags = s.BuildFlags
+	}
+	if s.Args != nil {
+		o.args = s.Args
+	}
+	return nil
+}
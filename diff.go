@@ -0,0 +1,133 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// unifiedDiff returns a unified diff of the texts a and b with the
+// given file names and three lines of context. It returns the empty
+// string if a and b are equal.
+func unifiedDiff(aName, bName, a, b string) string {
+	if a == b {
+		return ""
+	}
+	x := strings.SplitAfter(a, "\n")
+	if x[len(x)-1] == "" {
+		x = x[:len(x)-1]
+	}
+	y := strings.SplitAfter(b, "\n")
+	if y[len(y)-1] == "" {
+		y = y[:len(y)-1]
+	}
+
+	// lcs[i][j] is the length of the longest common
+	// subsequence of x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	type edit struct {
+		op   byte
+		line string
+		i, j int // line indexes in x and y before the edit
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			edits = append(edits, edit{' ', x[i], i, j})
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', x[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', y[j], i, j})
+			j++
+		}
+	}
+
+	const context = 3
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", aName, bName)
+	for k := 0; k < len(edits); {
+		if edits[k].op == ' ' {
+			k++
+			continue
+		}
+		// Find the extent of the hunk, merging changes
+		// separated by no more than twice the context.
+		start := max(k-context, 0)
+		end := k
+		for end < len(edits) {
+			if edits[end].op != ' ' {
+				end++
+				continue
+			}
+			n := end
+			for n < len(edits) && edits[n].op == ' ' {
+				n++
+			}
+			if n == len(edits) || n-end > 2*context {
+				break
+			}
+			end = n
+		}
+		end = min(end+context, len(edits))
+
+		var nx, ny int
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				nx++
+			}
+			if e.op != '-' {
+				ny++
+			}
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(edits[start].i, nx), hunkRange(edits[start].j, ny))
+		for _, e := range edits[start:end] {
+			buf.WriteByte(e.op)
+			buf.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		k = end
+	}
+	return buf.String()
+}
+
+// hunkRange returns the unified diff hunk range for n lines
+// starting at the zero-based line index i.
+func hunkRange(i, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", i)
+	}
+	if n == 1 {
+		return fmt.Sprint(i + 1)
+	}
+	return fmt.Sprintf("%d,%d", i+1, n)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
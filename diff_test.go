@@ -0,0 +1,116 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+var unifiedDiffTests = []struct {
+	name string
+	a, b string
+	want string
+}{
+	{
+		name: "equal",
+		a:    "a\nb\n",
+		b:    "a\nb\n",
+		want: "",
+	},
+	{
+		name: "single change",
+		a:    "a\nb\nc\n",
+		b:    "a\nB\nc\n",
+		want: `--- a
++++ b
+@@ -1,3 +1,3 @@
+ a
+-b
++B
+ c
+`,
+	},
+	{
+		name: "adjacent hunks",
+		a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+		b:    "1\nX\n3\n4\n5\n6\n7\n8\nY\n",
+		want: `--- a
++++ b
+@@ -1,9 +1,9 @@
+ 1
+-2
++X
+ 3
+ 4
+ 5
+ 6
+ 7
+ 8
+-9
++Y
+`,
+	},
+	{
+		name: "separated hunks",
+		a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+		b:    "X\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\nY\n",
+		want: `--- a
++++ b
+@@ -1,4 +1,4 @@
+-1
++X
+ 2
+ 3
+ 4
+@@ -9,4 +9,4 @@
+ 9
+ 10
+ 11
+-12
++Y
+`,
+	},
+	{
+		name: "insert at start",
+		a:    "",
+		b:    "a\nb\n",
+		want: `--- a
++++ b
+@@ -0,0 +1,2 @@
++a
++b
+`,
+	},
+	{
+		name: "delete all",
+		a:    "a\n",
+		b:    "",
+		want: `--- a
++++ b
+@@ -1 +0,0 @@
+-a
+`,
+	},
+	{
+		name: "missing final newline",
+		a:    "a\nb",
+		b:    "a\nb\n",
+		want: `--- a
++++ b
+@@ -1,2 +1,2 @@
+ a
+-b
+\ No newline at end of file
++b
+`,
+	},
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	for _, test := range unifiedDiffTests {
+		got := unifiedDiff("a", "b", test.a, test.b)
+		if got != test.want {
+			t.Errorf("unexpected diff for %s:\ngot:\n%s\nwant:\n%s", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/kortschak/gd/internal/enc"
+)
+
+// sourceStream is the stream of the event leading a saved event stream
+// that holds the hash of the document's source.
+const sourceStream = "source"
+
+// sourceHash returns the hash of the source src recorded in saved
+// event streams.
+func sourceHash(src []byte) string {
+	h := sha256.Sum256(src)
+	return hex.EncodeToString(h[:])
+}
+
+// saveEvents writes the document's trace to the file at path as a JSON
+// lines stream, led by an event holding the hash of the source.
+func saveEvents(path string, doc *document) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	events := append([]enc.Event{{
+		Stream: sourceStream,
+		File:   filepath.Base(doc.path),
+		Text:   sourceHash(doc.src),
+	}}, doc.trace...)
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, e := range events {
+		err = enc.Encode(e)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// replay sets the document's events from the JSON lines event stream
+// saved in the file at path. Events must have been saved from a source
+// with the same file name and contents as the document, and must match
+// calls in the document's source.
+func (d *document) replay(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	abs, err := filepath.Abs(d.path)
+	if err != nil {
+		return err
+	}
+	var trace []enc.Event
+	dec := json.NewDecoder(f)
+	for {
+		var e enc.Event
+		err = dec.Decode(&e)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch e.Stream {
+		case sourceStream:
+			if e.Text != sourceHash(d.src) {
+				return fmt.Errorf("%s: source has changed since events were saved from %s", path, e.File)
+			}
+			continue
+		case enc.BuildInfoStream:
+			trace = append(trace, e)
+			continue
+		}
+		if filepath.Base(e.File) != filepath.Base(d.path) {
+			return fmt.Errorf("%s: event from %s:%d does not belong to %s", path, e.File, e.Line, d.path)
+		}
+		e.File = abs
+		trace = append(trace, e)
+	}
+	err = d.collect(trace, true)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	godoc "go/doc"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/kortschak/gd/internal/enc"
+)
+
+// example is an example function in a Go test source.
+type example struct {
+	// name is the name of the example function.
+	name string
+	// doc is the text of the function's doc comment
+	// and docStart and docEnd are its first and last
+	// lines. They are zero if there is no comment.
+	doc              string
+	docStart, docEnd int
+	// start and end are the first and last lines
+	// of the function declaration.
+	start, end int
+	// run is whether the example has an output
+	// comment. Examples without an output comment
+	// are compiled but not run by go test.
+	run bool
+}
+
+// isTestSource returns whether path is a Go test source.
+func isTestSource(path string) bool {
+	return strings.HasSuffix(path, "_test.go")
+}
+
+// parseExamples reads and parses the Go test source at path, rewriting
+// its imports to use the gd hook packages, and finds its examples.
+func parseExamples(path string) (*document, error) {
+	// The test is built in the package directory, so line
+	// directives in the program must use absolute paths.
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parse(abs)
+	if err != nil {
+		return nil, err
+	}
+	doc.path = path
+	// Output comments of examples are checked by go test.
+	doc.expects = nil
+
+	funcs := make(map[string]*ast.FuncDecl)
+	for _, decl := range doc.file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if ok && fn.Recv == nil {
+			funcs[fn.Name.Name] = fn
+		}
+	}
+	doc.examples = []example{}
+	for _, ex := range godoc.Examples(doc.file) {
+		name := "Example" + ex.Name
+		fn, ok := funcs[name]
+		if !ok {
+			continue
+		}
+		e := example{
+			name:  name,
+			doc:   ex.Doc,
+			start: doc.fset.Position(fn.Pos()).Line,
+			end:   doc.fset.Position(fn.End()).Line,
+			run:   ex.Output != "" || ex.EmptyOutput,
+		}
+		if fn.Doc != nil {
+			e.docStart = doc.fset.Position(fn.Doc.Pos()).Line
+			e.docEnd = doc.fset.Position(fn.Doc.End()).Line
+		}
+		doc.examples = append(doc.examples, e)
+	}
+	// Render examples in source order.
+	sort.Slice(doc.examples, func(i, j int) bool {
+		return doc.examples[i].start < doc.examples[j].start
+	})
+	return doc, nil
+}
+
+// failedExample matches the go test report line for a failed example.
+var failedExample = regexp.MustCompile(`^--- FAIL: (Example\w*) `)
+
+// runExamples builds the test binary for the package holding the
+// document's test source, replacing the source with the rewritten
+// program src, and runs the document's examples. It returns the
+// collected output events, the names of the examples whose output did
+// not match their output comment and, if the test binary was stopped
+// by a limit, a description of the limit. The go test report is written
+// to stderr.
+func (d *document) runExamples(src []byte, env []string, opts options) (events []enc.Event, failed []string, halted string, err error) {
+	path, err := filepath.Abs(d.path)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	dir, err := ioutil.TempDir("", "gd-")
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer os.RemoveAll(dir)
+	prog := filepath.Join(dir, filepath.Base(path))
+	err = ioutil.WriteFile(prog, src, 0666)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	overlay, err := json.Marshal(struct{ Replace map[string]string }{
+		Replace: map[string]string{path: prog},
+	})
+	if err != nil {
+		return nil, nil, "", err
+	}
+	overlayPath := filepath.Join(dir, "overlay.json")
+	err = ioutil.WriteFile(overlayPath, overlay, 0666)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	bin := filepath.Join(dir, "prog.test")
+	args := append([]string{"test", "-c"}, opts.buildArgs()[1:]...)
+	args = append(args, "-overlay", overlayPath, "-o", bin, filepath.Dir(path))
+	gotest := exec.Command("go", args...)
+	if len(opts.env) != 0 {
+		gotest.Env = append(os.Environ(), opts.env...)
+	}
+	gotest.Stdout = os.Stderr
+	gotest.Stderr = os.Stderr
+	err = gotest.Run()
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	var names []string
+	for _, ex := range d.examples {
+		if ex.run {
+			names = append(names, ex.name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil, "", nil
+	}
+	test := exec.Command(bin, append([]string{"-test.run", "^(" + strings.Join(names, "|") + ")$"}, d.args...)...)
+	// Tests are run in the package directory.
+	test.Dir = filepath.Dir(path)
+	env = append(env[:len(env):len(env)], enc.ExamplesEnv+"=1")
+	stdout, halted, runErr := execute(test, env, d.stdin, opts)
+
+	// The test binary's standard output holds the output
+	// events interleaved with the go test report.
+	var report bytes.Buffer
+	lines := bytes.SplitAfter(stdout, []byte("\n"))
+	for i, l := range lines {
+		if len(l) == 0 {
+			continue
+		}
+		if bytes.HasPrefix(l, []byte("{")) {
+			var e enc.Event
+			err = json.Unmarshal(l, &e)
+			if err == nil && e.Stream != "" {
+				events = append(events, e)
+				continue
+			}
+			if halted != "" && i == len(lines)-1 {
+				// The last event may have been
+				// cut short by the limit.
+				break
+			}
+		}
+		if string(l) == "PASS\n" {
+			continue
+		}
+		m := failedExample.FindSubmatch(l)
+		if m != nil {
+			failed = append(failed, string(m[1]))
+		}
+		report.Write(l)
+	}
+	_, err = io.Copy(os.Stderr, &report)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	var exitErr *exec.ExitError
+	if runErr != nil && !(len(failed) != 0 && errors.As(runErr, &exitErr)) {
+		return nil, nil, "", runErr
+	}
+	return events, failed, halted, nil
+}
+
+// renderExamples renders the examples of a Go test source document to
+// out as Markdown, with each example's doc comment as prose followed
+// by its code and output.
+func renderExamples(out io.Writer, doc *document, inline, quote bool) error {
+	ticks := doc.fence()
+	lines := strings.SplitAfter(string(doc.src), "\n")
+	for i, ex := range doc.examples {
+		if i != 0 {
+			_, err := fmt.Fprintln(out)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := fmt.Fprintf(out, "## %s\n\n", ex.name)
+		if err != nil {
+			return err
+		}
+		if ex.doc != "" {
+			_, err = fmt.Fprintf(out, "%s\n", ex.doc)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = fmt.Fprintf(out, "%sgo\n%s", ticks, strings.Join(lines[ex.start-1:ex.end], ""))
+		if err != nil {
+			return err
+		}
+		if !strings.HasSuffix(lines[ex.end-1], "\n") {
+			_, err = fmt.Fprintln(out)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = fmt.Fprintln(out, ticks)
+		if err != nil {
+			return err
+		}
+		events := doc.exampleEvents(ex)
+		if len(events) != 0 {
+			_, err = fmt.Fprintln(out)
+			if err != nil {
+				return err
+			}
+		}
+		for _, r := range events {
+			err = renderEvents(out, doc, r, ticks, inline, quote)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if doc.halted != "" {
+		_, err := fmt.Fprintln(out)
+		if err != nil {
+			return err
+		}
+		return renderEvents(out, doc, []enc.Event{doc.haltEvent()}, ticks, inline, quote)
+	}
+	return nil
+}
+
+// exampleEvents returns the events attributed to lines within the
+// example ex, in line order.
+func (d *document) exampleEvents(ex example) [][]enc.Event {
+	return d.blockEvents(block{start: ex.start - 1, end: ex.end + 1})
+}
+
+// exampleChunks returns the ordered chunks of a Go test source
+// document. Images are referenced by file name unless inline is true.
+func (d *document) exampleChunks(inline bool) ([]chunk, error) {
+	lines := strings.SplitAfter(string(d.src), "\n")
+	var chunks []chunk
+	for _, ex := range d.examples {
+		text := "## " + ex.name + "\n"
+		if ex.doc != "" {
+			text += "\n" + ex.doc
+		}
+		start, end := ex.docStart, ex.docEnd
+		if ex.doc == "" {
+			// The prose is only the heading.
+			start, end = ex.start, ex.start
+		}
+		chunks = append(chunks, chunk{
+			Kind:  "prose",
+			Start: start,
+			End:   end,
+			Text:  text,
+		})
+		code := strings.Join(lines[ex.start-1:ex.end], "")
+		if !strings.HasSuffix(code, "\n") {
+			code += "\n"
+		}
+		chunks = append(chunks, chunk{
+			Kind:  "code",
+			Start: ex.start,
+			End:   ex.end,
+			Text:  code,
+		})
+
+		var events []output
+		for _, r := range d.exampleEvents(ex) {
+			o, err := d.outputs(r, inline)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, o...)
+		}
+		if len(events) != 0 {
+			chunks = append(chunks, chunk{Kind: "output", Start: ex.end, End: ex.end, Events: events})
+		}
+	}
+	return chunks, nil
+}
+
+// packageSources returns the paths of the Go files in the package
+// directory of the document's test source other than the source.
+func (d *document) packageSources() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(d.path), "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	sources := files[:0]
+	for _, f := range files {
+		if filepath.Clean(f) != filepath.Clean(d.path) {
+			sources = append(sources, f)
+		}
+	}
+	return sources, nil
+}
@@ -13,7 +13,6 @@ immediately following the `/*` comment opening.
 package main
 
 import (
-	"bytes"
 	"fmt"
 	"image"
 	"log"
@@ -38,9 +37,9 @@ var locations = []string{
 }
 
 func main() {
-	var buf bytes.Buffer
-	fmt.Fprintln(&buf, "|URL|Format|File|")
-	fmt.Fprintln(&buf, "|---|------|----|")
+	w := show.Writer("markdown")
+	fmt.Fprintln(w, "|URL|Format|File|")
+	fmt.Fprintln(w, "|---|------|----|")
 	for _, url := range locations {
 		resp, err := http.Get(url)
 		if err != nil {
@@ -54,7 +53,7 @@ func main() {
 		if err != nil {
 			log.Fatal(err)
 		}
-		fmt.Fprintf(&buf, "|%s|%s|%s|\n", url, format, path.Base(url))
+		fmt.Fprintf(w, "|%s|%s|%s|\n", url, format, path.Base(url))
 	}
-	show.Markdown(buf.String())
+	w.Close()
 }
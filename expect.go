@@ -0,0 +1,242 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// expectation is a comment in a gd source holding the output expected
+// from the lines preceding it.
+type expectation struct {
+	// start and end are the first and last lines of
+	// the comment, and col and endCol are the columns
+	// of its first byte and the byte following it.
+	start, end  int
+	col, endCol int
+	// from is the last line before the lines whose
+	// output is expected. It is the last line of the
+	// previous expectation or {md} comment, or zero.
+	from int
+
+	// block is whether the expectation is written as
+	// a /*{expect} ... */ comment rather than as an
+	// // Output: comment.
+	block bool
+	// text is the expected output.
+	text string
+}
+
+// findExpectations returns the /*{expect} ... */ comments of f and,
+// if output is true, its // Output: comments in source order. The
+// lines of the {md} comments in mdText separate the regions of output
+// that the expectations hold.
+func findExpectations(fset *token.FileSet, f *ast.File, mdText map[int]*ast.Comment, output bool) []expectation {
+	var (
+		expects []expectation
+		bounds  []int
+	)
+	for _, c := range mdText {
+		bounds = append(bounds, fset.Position(c.End()).Line)
+	}
+	for _, g := range f.Comments {
+		first := g.List[0]
+		var e expectation
+		switch {
+		case strings.HasPrefix(first.Text, "/*{expect}\n"):
+			e = expectation{block: true, text: blockExpectation(fset, first)}
+			g = &ast.CommentGroup{List: g.List[:1]}
+		case output && strings.HasPrefix(first.Text, "// Output:"):
+			e = expectation{text: outputExpectation(g)}
+		default:
+			continue
+		}
+		start := fset.Position(g.Pos())
+		end := fset.Position(g.End())
+		e.start, e.col = start.Line, start.Column
+		e.end, e.endCol = end.Line, end.Column
+		expects = append(expects, e)
+		bounds = append(bounds, e.end)
+	}
+	sort.Ints(bounds)
+	for i, e := range expects {
+		for _, b := range bounds {
+			if b >= e.start {
+				break
+			}
+			expects[i].from = b
+		}
+	}
+	return expects
+}
+
+// blockExpectation returns the text held in the {expect} comment c with
+// the comment markers and the comment's indentation removed.
+func blockExpectation(fset *token.FileSet, c *ast.Comment) string {
+	text := strings.TrimPrefix(c.Text, "/*{expect}")
+	text = strings.TrimSuffix(text, "*/")
+	indent := fset.Position(c.Pos()).Column - 1
+	return strings.Replace(text, "\n"+strings.Repeat("\t", indent), "\n", -1)
+}
+
+// outputExpectation returns the text held in the // Output: comment
+// group g.
+func outputExpectation(g *ast.CommentGroup) string {
+	var buf strings.Builder
+	for i, c := range g.List {
+		text := c.Text
+		if i == 0 {
+			text = strings.TrimPrefix(text, "// Output:")
+		} else {
+			text = strings.TrimPrefix(text, "//")
+		}
+		buf.WriteString(strings.TrimPrefix(text, " "))
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// outputText returns the text written to stdout and stderr by the
+// lines of the document after from and before to.
+func (d *document) outputText(from, to int) string {
+	var buf strings.Builder
+	for _, r := range d.blockEvents(block{start: from, end: to}) {
+		for _, e := range r {
+			switch e.Stream {
+			case "stdout", "stderr":
+				buf.WriteString(e.Text)
+			}
+		}
+	}
+	return buf.String()
+}
+
+// mismatch is an expectation that does not hold.
+type mismatch struct {
+	expectation
+	// got is the output of the expectation's lines.
+	got string
+}
+
+// mismatches returns the document's expectations that do not hold.
+// Leading and trailing space is ignored when comparing output.
+func (d *document) mismatches() []mismatch {
+	var m []mismatch
+	for _, e := range d.expects {
+		got := d.outputText(e.from, e.start)
+		if strings.TrimSpace(got) != strings.TrimSpace(e.text) {
+			m = append(m, mismatch{expectation: e, got: got})
+		}
+	}
+	return m
+}
+
+// reportMismatches writes a diff for each of the document's expectations
+// that does not hold to w and returns the number of mismatches.
+func (d *document) reportMismatches(w io.Writer) int {
+	m := d.mismatches()
+	for _, e := range m {
+		fmt.Fprintf(w, "%s:%d: output does not match expectation\n", d.path, e.start)
+		fmt.Fprint(w, unifiedDiff("expected", "got", trimmedLines(e.text), trimmedLines(e.got)))
+	}
+	return len(m)
+}
+
+// trimmedLines returns s without leading and trailing space,
+// terminated by a newline if it is not empty.
+func trimmedLines(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	}
+	return s + "\n"
+}
+
+// updateExpectations rewrites the expectations in the document's source
+// that do not hold to hold the document's output. It returns whether
+// the source was changed.
+func (d *document) updateExpectations() (bool, error) {
+	m := d.mismatches()
+	if len(m) == 0 {
+		return false, nil
+	}
+	if d.halted != "" {
+		return false, errors.New("cannot update expectations: program was stopped by a limit")
+	}
+	fi, err := os.Stat(d.path)
+	if err != nil {
+		return false, err
+	}
+	lines := strings.SplitAfter(string(d.src), "\n")
+	// Rewrite from the end so that the lines of earlier
+	// expectations are not moved.
+	for i := len(m) - 1; i >= 0; i-- {
+		e := m[i]
+		prefix := lines[e.start-1][:e.col-1]
+		suffix := lines[e.end-1][e.endCol-1:]
+		indent := prefix[:len(prefix)-len(strings.TrimLeft(prefix, " \t"))]
+		var comment string
+		if e.block {
+			comment = blockComment(e.got, indent)
+		} else {
+			comment = outputComment(e.got, indent)
+		}
+		rewritten := strings.SplitAfter(prefix+comment+suffix, "\n")
+		lines = append(lines[:e.start-1], append(rewritten, lines[e.end:]...)...)
+	}
+	err = ioutil.WriteFile(d.path, []byte(strings.Join(lines, "")), fi.Mode())
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// blockComment returns a /*{expect} ... */ comment holding text, with
+// its lines indented by indent.
+func blockComment(text, indent string) string {
+	var buf strings.Builder
+	buf.WriteString("/*{expect}\n")
+	text = strings.TrimSpace(text)
+	if text != "" {
+		for _, l := range strings.Split(text, "\n") {
+			if l != "" {
+				buf.WriteString(indent)
+			}
+			buf.WriteString(l)
+			buf.WriteByte('\n')
+		}
+	}
+	buf.WriteString(indent)
+	buf.WriteString("*/")
+	return buf.String()
+}
+
+// outputComment returns an // Output: comment holding text, with its
+// lines indented by indent.
+func outputComment(text, indent string) string {
+	var buf strings.Builder
+	buf.WriteString("// Output:")
+	text = strings.TrimSpace(text)
+	if text != "" {
+		for _, l := range strings.Split(text, "\n") {
+			buf.WriteByte('\n')
+			buf.WriteString(indent)
+			buf.WriteString("//")
+			if l != "" {
+				buf.WriteByte(' ')
+				buf.WriteString(l)
+			}
+		}
+	}
+	return buf.String()
+}
@@ -10,9 +10,10 @@
 // the the standard library fmt package. Not all functions make sense
 // to use, but all are replicated from the stdlib fmt package.
 // Printing functions that print to os.Stdout and os.Stderr are written
-// to a JSON stream that is output to os.Stdout. All other output
-// io.Writers are treated as normal and all other functions behave as
-// the stdlib fmt functions.
+// to a JSON stream that is output to os.Stdout. Text consumed by
+// scanning functions that read from os.Stdin is echoed to the stream.
+// All other io.Writers and io.Readers are treated as normal and all
+// other functions behave as the stdlib fmt functions.
 package fmt
 
 import (
@@ -90,6 +91,11 @@ func Fprintln(w io.Writer, a ...interface{}) (n int, err error) {
 // returns the number of items successfully scanned. If that is less
 // than the number of arguments, err will report why.
 func Fscan(r io.Reader, a ...interface{}) (n int, err error) {
+	if r == os.Stdin {
+		n, err = fmt.Fscan(stdin, a...)
+		stdin.echo(1)
+		return n, err
+	}
 	return fmt.Fscan(r, a...)
 }
 
@@ -98,12 +104,22 @@ func Fscan(r io.Reader, a ...interface{}) (n int, err error) {
 // returns the number of items successfully parsed.
 // Newlines in the input must match newlines in the format.
 func Fscanf(r io.Reader, format string, a ...interface{}) (n int, err error) {
+	if r == os.Stdin {
+		n, err = fmt.Fscanf(stdin, format, a...)
+		stdin.echo(1)
+		return n, err
+	}
 	return fmt.Fscanf(r, format, a...)
 }
 
 // Fscanln is similar to Fscan, but stops scanning at a newline and
 // after the final item there must be a newline or EOF.
 func Fscanln(r io.Reader, a ...interface{}) (n int, err error) {
+	if r == os.Stdin {
+		n, err = fmt.Fscanln(stdin, a...)
+		stdin.echo(1)
+		return n, err
+	}
 	return fmt.Fscanln(r, a...)
 }
 
@@ -147,7 +163,9 @@ func Println(a ...interface{}) (n int, err error) {
 // as space. It returns the number of items successfully scanned.
 // If that is less than the number of arguments, err will report why.
 func Scan(a ...interface{}) (n int, err error) {
-	return fmt.Scan(a...)
+	n, err = fmt.Fscan(stdin, a...)
+	stdin.echo(1)
+	return n, err
 }
 
 // Scanf scans text read from standard input, storing successive
@@ -158,13 +176,17 @@ func Scan(a ...interface{}) (n int, err error) {
 // The one exception: the verb %c always scans the next rune in the
 // input, even if it is a space (or tab etc.) or newline.
 func Scanf(format string, a ...interface{}) (n int, err error) {
-	return fmt.Scanf(format, a...)
+	n, err = fmt.Fscanf(stdin, format, a...)
+	stdin.echo(1)
+	return n, err
 }
 
 // Scanln is similar to Scan, but stops scanning at a newline and
 // after the final item there must be a newline or EOF.
 func Scanln(a ...interface{}) (n int, err error) {
-	return fmt.Scanln(a...)
+	n, err = fmt.Fscanln(stdin, a...)
+	stdin.echo(1)
+	return n, err
 }
 
 // Sprint formats using the default formats for its operands and returns the resulting string.
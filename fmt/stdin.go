@@ -0,0 +1,117 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fmt
+
+import (
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/kortschak/gd/internal/enc"
+)
+
+// stdin is the reader used by scanning functions that read from
+// os.Stdin. It records the text consumed by each scan so that it
+// can be echoed.
+var stdin = &recorder{r: os.Stdin}
+
+// encode writes events to the JSON stream.
+var encode = enc.Encode
+
+// recorder is an io.RuneScanner that records the text read from r.
+// It reads a byte at a time so that no more input is consumed from
+// r than the scanning functions need, leaving the remainder for
+// other readers of os.Stdin.
+type recorder struct {
+	mu sync.Mutex
+	r  io.Reader
+
+	// text is the text read since the last echo.
+	text []byte
+
+	// pending is the rune held by UnreadRune.
+	pending    rune
+	pendSize   int
+	hasPending bool
+	last       rune
+	lastSize   int
+}
+
+// Read reads a single rune into p. The scanning functions
+// use ReadRune, so Read is provided only to satisfy io.Reader.
+func (r *recorder) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	c, _, err := r.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	return copy(p, string(c)), nil
+}
+
+func (r *recorder) ReadRune() (c rune, size int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.hasPending {
+		r.hasPending = false
+		c, size = r.pending, r.pendSize
+	} else {
+		var buf [utf8.UTFMax]byte
+		for size < len(buf) && !utf8.FullRune(buf[:size]) {
+			var n int
+			n, err = r.r.Read(buf[size : size+1])
+			size += n
+			if err != nil {
+				break
+			}
+		}
+		if size == 0 {
+			return 0, 0, err
+		}
+		c, size = utf8.DecodeRune(buf[:size])
+		err = nil
+	}
+	var buf [utf8.UTFMax]byte
+	r.text = append(r.text, buf[:utf8.EncodeRune(buf[:], c)]...)
+	r.last, r.lastSize = c, size
+	return c, size, nil
+}
+
+func (r *recorder) UnreadRune() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.lastSize == 0 {
+		return io.ErrNoProgress
+	}
+	r.pending, r.pendSize = r.last, r.lastSize
+	r.hasPending = true
+	r.lastSize = 0
+	r.text = r.text[:len(r.text)-utf8.RuneLen(r.pending)]
+	return nil
+}
+
+// echo writes the text consumed since the last echo, without leading
+// white space, to the JSON stream as a stdin event. It is called at the
+// end of each scan. The fmt scanning functions discard a rune unread at
+// the end of a scan of a reader that is not an io.RuneScanner, such as
+// os.Stdin, so a pending rune is dropped and echoed as consumed.
+func (r *recorder) echo(depth int) error {
+	r.mu.Lock()
+	if r.hasPending {
+		var buf [utf8.UTFMax]byte
+		r.text = append(r.text, buf[:utf8.EncodeRune(buf[:], r.pending)]...)
+		r.hasPending = false
+	}
+	text := strings.TrimLeft(string(r.text), " \t\r\n")
+	r.text = r.text[:0]
+	r.mu.Unlock()
+	if text == "" {
+		return nil
+	}
+	return encode(enc.Event{Stream: "stdin", Text: text}, depth+1)
+}
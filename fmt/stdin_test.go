@@ -0,0 +1,49 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fmt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kortschak/gd/internal/enc"
+)
+
+func TestScanThenScanln(t *testing.T) {
+	defer func(r *recorder, e func(enc.Event, int) error) {
+		stdin, encode = r, e
+	}(stdin, encode)
+
+	stdin = &recorder{r: strings.NewReader("bob\n42\n")}
+	var echoed []string
+	encode = func(e enc.Event, _ int) error {
+		if e.Stream != "stdin" {
+			t.Errorf("unexpected stream: got:%q want:%q", e.Stream, "stdin")
+		}
+		echoed = append(echoed, e.Text)
+		return nil
+	}
+
+	var (
+		name string
+		n    int
+	)
+	_, err := Scan(&name)
+	if err != nil {
+		t.Errorf("unexpected error from Scan: %v", err)
+	}
+	_, err = Scanln(&n)
+	if err != nil {
+		t.Errorf("unexpected error from Scanln: %v", err)
+	}
+	if name != "bob" || n != 42 {
+		t.Errorf("unexpected values: got:%q %d want:%q %d", name, n, "bob", 42)
+	}
+	want := []string{"bob\n", "42\n"}
+	if !reflect.DeepEqual(echoed, want) {
+		t.Errorf("unexpected echoed input: got:%q want:%q", echoed, want)
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// markdownToHTML returns an HTML rendering of the Markdown text. It
+// handles the subset of Markdown used in gd documents: ATX headings,
+// paragraphs, fenced code blocks, block quotes, lists, pipe tables,
+// thematic breaks, raw HTML blocks and code span, emphasis, link and
+// image inlines.
+func markdownToHTML(text string) string {
+	var buf strings.Builder
+	renderBlocks(&buf, strings.Split(strings.TrimSuffix(text, "\n"), "\n"))
+	return buf.String()
+}
+
+var (
+	heading   = regexp.MustCompile(`^(#{1,6})[ \t]+(.*?)[ \t#]*$`)
+	listItem  = regexp.MustCompile(`^[ ]{0,3}([-*+]|[0-9]+[.)])[ \t]+(.*)$`)
+	rule      = regexp.MustCompile(`^[ ]{0,3}(?:(?:-[ \t]*){3,}|(?:\*[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	tableRule = regexp.MustCompile(`^\|?[ \t]*:?-+:?[ \t]*(\|[ \t]*:?-+:?[ \t]*)*\|?$`)
+)
+
+// renderBlocks renders the Markdown block structure of lines to buf.
+func renderBlocks(buf *strings.Builder, lines []string) {
+	var para []string
+	flush := func() {
+		if len(para) != 0 {
+			buf.WriteString("<p>")
+			buf.WriteString(renderInline(strings.Join(para, "\n")))
+			buf.WriteString("</p>\n")
+			para = nil
+		}
+	}
+	for i := 0; i < len(lines); i++ {
+		l := lines[i]
+		switch {
+		case strings.TrimSpace(l) == "":
+			flush()
+
+		case strings.HasPrefix(l, "```") || strings.HasPrefix(l, "~~~"):
+			flush()
+			fence, _ := openingFence(l)
+			info := strings.Fields(l[len(fence):])
+			var code []string
+			for i++; i < len(lines) && !isClosingFence(lines[i], fence); i++ {
+				code = append(code, lines[i])
+			}
+			if len(info) == 0 {
+				buf.WriteString("<pre><code>")
+			} else {
+				buf.WriteString(`<pre class="` + html.EscapeString(info[0]) + `"><code class="language-` + html.EscapeString(info[0]) + `">`)
+			}
+			for _, c := range code {
+				buf.WriteString(html.EscapeString(c))
+				buf.WriteByte('\n')
+			}
+			buf.WriteString("</code></pre>\n")
+
+		case heading.MatchString(l):
+			flush()
+			m := heading.FindStringSubmatch(l)
+			n := string('0' + rune(len(m[1])))
+			buf.WriteString("<h" + n + ">" + renderInline(m[2]) + "</h" + n + ">\n")
+
+		case rule.MatchString(l) && len(para) == 0:
+			buf.WriteString("<hr>\n")
+
+		case strings.HasPrefix(strings.TrimLeft(l, " "), ">"):
+			flush()
+			var quote []string
+			for ; i < len(lines); i++ {
+				q := strings.TrimLeft(lines[i], " ")
+				if !strings.HasPrefix(q, ">") {
+					break
+				}
+				q = strings.TrimPrefix(q, ">")
+				quote = append(quote, strings.TrimPrefix(q, " "))
+			}
+			i--
+			buf.WriteString("<blockquote>\n")
+			renderBlocks(buf, quote)
+			buf.WriteString("</blockquote>\n")
+
+		case listItem.MatchString(l):
+			flush()
+			tag := "ul"
+			if m := listItem.FindStringSubmatch(l); m[1][0] >= '0' && m[1][0] <= '9' {
+				tag = "ol"
+			}
+			buf.WriteString("<" + tag + ">\n")
+			for i < len(lines) {
+				m := listItem.FindStringSubmatch(lines[i])
+				if m == nil {
+					break
+				}
+				item := []string{m[2]}
+				for i++; i < len(lines); i++ {
+					c := lines[i]
+					if strings.TrimSpace(c) == "" || listItem.MatchString(c) || !strings.HasPrefix(c, " ") {
+						break
+					}
+					item = append(item, strings.TrimSpace(c))
+				}
+				buf.WriteString("<li>" + renderInline(strings.Join(item, "\n")) + "</li>\n")
+			}
+			i--
+			buf.WriteString("</" + tag + ">\n")
+
+		case strings.HasPrefix(l, "|") && i+1 < len(lines) && tableRule.MatchString(lines[i+1]):
+			flush()
+			buf.WriteString("<table>\n<tr>")
+			for _, c := range tableCells(l) {
+				buf.WriteString("<th>" + renderInline(c) + "</th>")
+			}
+			buf.WriteString("</tr>\n")
+			for i += 2; i < len(lines) && strings.HasPrefix(lines[i], "|"); i++ {
+				buf.WriteString("<tr>")
+				for _, c := range tableCells(lines[i]) {
+					buf.WriteString("<td>" + renderInline(c) + "</td>")
+				}
+				buf.WriteString("</tr>\n")
+			}
+			i--
+			buf.WriteString("</table>\n")
+
+		case strings.HasPrefix(l, "<!--"):
+			flush()
+			for ; i < len(lines); i++ {
+				buf.WriteString(lines[i])
+				buf.WriteByte('\n')
+				if strings.Contains(lines[i], "-->") {
+					break
+				}
+			}
+
+		case strings.HasPrefix(l, "<") && len(para) == 0:
+			for ; i < len(lines) && strings.TrimSpace(lines[i]) != ""; i++ {
+				buf.WriteString(lines[i])
+				buf.WriteByte('\n')
+			}
+
+		default:
+			para = append(para, strings.TrimSpace(l))
+		}
+	}
+	flush()
+}
+
+// tableCells returns the cells of a pipe table row. Escaped pipes
+// do not separate cells.
+func tableCells(row string) []string {
+	row = strings.TrimSpace(row)
+	row = strings.TrimPrefix(row, "|")
+	if strings.HasSuffix(row, "|") && !strings.HasSuffix(row, `\|`) {
+		row = row[:len(row)-1]
+	}
+	var cells []string
+	start := 0
+	for i := 0; i < len(row); i++ {
+		switch row[i] {
+		case '\\':
+			i++
+		case '|':
+			cells = append(cells, strings.TrimSpace(row[start:i]))
+			start = i + 1
+		}
+	}
+	return append(cells, strings.TrimSpace(row[start:]))
+}
+
+var (
+	linkTail = regexp.MustCompile(`^\(([^ )]*)(?:[ \t]+"((?:[^"\\]|\\.)*)")?\)`)
+	rawTag   = regexp.MustCompile(`^</?[a-zA-Z][^>]*>|^<!--.*?-->`)
+)
+
+// renderInline renders the Markdown inlines of text as HTML.
+func renderInline(text string) string {
+	var (
+		buf    strings.Builder
+		strong bool
+		em     bool
+	)
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && strings.IndexByte("\\`*_{}[]()#+-.!|<>", text[i+1]) >= 0:
+			i++
+			buf.WriteString(html.EscapeString(text[i : i+1]))
+
+		case c == '`':
+			n := len(text[i:]) - len(strings.TrimLeft(text[i:], "`"))
+			ticks := text[i : i+n]
+			end := strings.Index(text[i+n:], ticks)
+			if end < 0 {
+				buf.WriteString(ticks)
+				i += n - 1
+				continue
+			}
+			code := strings.TrimSpace(text[i+n : i+n+end])
+			buf.WriteString("<code>" + html.EscapeString(code) + "</code>")
+			i += n + end + n - 1
+
+		case c == '[' || (c == '!' && strings.HasPrefix(text[i:], "![")):
+			start := i
+			if c == '!' {
+				start++
+			}
+			end := strings.IndexByte(text[start:], ']')
+			if end < 0 {
+				buf.WriteString(html.EscapeString(text[i : start+1]))
+				i = start
+				continue
+			}
+			end += start
+			m := linkTail.FindStringSubmatch(text[end+1:])
+			if m == nil {
+				buf.WriteString(html.EscapeString(text[i : start+1]))
+				i = start
+				continue
+			}
+			label := text[start+1 : end]
+			var title string
+			if m[2] != "" {
+				title = ` title="` + html.EscapeString(unquote(m[2])) + `"`
+			}
+			if c == '!' {
+				buf.WriteString(`<img src="` + html.EscapeString(m[1]) + `" alt="` + html.EscapeString(label) + `"` + title + `>`)
+			} else {
+				buf.WriteString(`<a href="` + html.EscapeString(m[1]) + `"` + title + `>` + renderInline(label) + `</a>`)
+			}
+			i = end + len(m[0])
+
+		case (c == '*' || c == '_') && i+1 < len(text) && text[i+1] == c:
+			if strong {
+				buf.WriteString("</strong>")
+			} else {
+				buf.WriteString("<strong>")
+			}
+			strong = !strong
+			i++
+
+		case c == '*' || (c == '_' && (i == 0 || !isWordByte(text[i-1]) || em)):
+			if em {
+				buf.WriteString("</em>")
+			} else {
+				buf.WriteString("<em>")
+			}
+			em = !em
+
+		case c == '<' && rawTag.MatchString(text[i:]):
+			tag := rawTag.FindString(text[i:])
+			buf.WriteString(tag)
+			i += len(tag) - 1
+
+		default:
+			buf.WriteString(html.EscapeString(text[i : i+1]))
+		}
+	}
+	if em {
+		buf.WriteString("</em>")
+	}
+	if strong {
+		buf.WriteString("</strong>")
+	}
+	return buf.String()
+}
+
+// unquote removes backslash escapes from s.
+func unquote(s string) string {
+	return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(s)
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
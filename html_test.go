@@ -0,0 +1,58 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+var markdownToHTMLTests = []struct {
+	name string
+	text string
+	want string
+}{
+	{
+		name: "fence in quote",
+		text: "> ```\n> a < b\n> ```\nafter\n",
+		want: "<blockquote>\n<pre><code>a &lt; b\n</code></pre>\n</blockquote>\n<p>after</p>\n",
+	},
+	{
+		name: "fence with info in quote",
+		text: "> text\n> ```go\n> x := 1 // > y\n>\n> ```\n",
+		want: "<blockquote>\n<p>text</p>\n" +
+			`<pre class="go"><code class="language-go">x := 1 // &gt; y` + "\n\n</code></pre>\n" +
+			"</blockquote>\n",
+	},
+	{
+		name: "table in fence",
+		text: "```text\n| not | a table |\n|---|---|\n```\n",
+		want: `<pre class="text"><code class="language-text">| not | a table |` + "\n|---|---|\n</code></pre>\n",
+	},
+	{
+		name: "table",
+		text: "| a | b |\n|---|--:|\n| 1 | 2 |\n",
+		want: "<table>\n<tr><th>a</th><th>b</th></tr>\n<tr><td>1</td><td>2</td></tr>\n</table>\n",
+	},
+	{
+		name: "table with escaped pipes",
+		text: "|a|b|\n|---|---|\n|x \\| y|`c`|\n|z|\\||\n",
+		want: "<table>\n<tr><th>a</th><th>b</th></tr>\n" +
+			"<tr><td>x | y</td><td><code>c</code></td></tr>\n" +
+			"<tr><td>z</td><td>|</td></tr>\n" +
+			"</table>\n",
+	},
+	{
+		name: "table with line breaks",
+		text: "|a|\n|---|\n|x<br>y|\n",
+		want: "<table>\n<tr><th>a</th></tr>\n<tr><td>x<br>y</td></tr>\n</table>\n",
+	},
+}
+
+func TestMarkdownToHTML(t *testing.T) {
+	for _, test := range markdownToHTMLTests {
+		got := markdownToHTML(test.text)
+		if got != test.want {
+			t.Errorf("unexpected HTML for %s:\ngot: %q\nwant:%q", test.name, got, test.want)
+		}
+	}
+}
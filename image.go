@@ -0,0 +1,103 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/kortschak/gd/internal/enc"
+)
+
+// decodeImage returns the image data and format of the image
+// held by the image event e.
+func decodeImage(e enc.Event) (data []byte, format string, err error) {
+	switch {
+	case strings.HasPrefix(e.Image, "data:image/jpeg;base64,"):
+		data, err = base64.StdEncoding.DecodeString(strings.TrimPrefix(e.Image, "data:image/jpeg;base64,"))
+		format = "jpeg"
+	case strings.HasPrefix(e.Image, "data:image/png;base64,"):
+		data, err = base64.StdEncoding.DecodeString(strings.TrimPrefix(e.Image, "data:image/png;base64,"))
+		format = "png"
+	case strings.HasPrefix(e.Image, "data:image/svg+xml,"):
+		data = []byte(strings.TrimPrefix(e.Image, "data:image/svg+xml,"))
+		format = "svg"
+	default:
+		return nil, "", fmt.Errorf("unknown image format: %s", e.Image)
+	}
+	return data, format, err
+}
+
+// imageName returns the name of the file holding the image held
+// by the image event e, the ith of n events on its line. The name
+// is a slash-separated path relative to the rendered document.
+func (d *document) imageName(e enc.Event, i, n int) (string, error) {
+	_, format, err := decodeImage(e)
+	if err != nil {
+		return "", err
+	}
+	base := filepath.Base(e.File)
+	ext := filepath.Ext(base)
+	base = base[:len(base)-len(ext)] + d.variant
+	var name string
+	if n == 1 {
+		name = fmt.Sprintf("%s_%d.%s", base, e.Line, format)
+	} else {
+		name = fmt.Sprintf("%s_%d_%d.%s", base, e.Line, i, format)
+	}
+	if d.imageDir == "" {
+		return name, nil
+	}
+	return path.Join(filepath.ToSlash(d.imageDir), name), nil
+}
+
+// images returns the images held by the document's image events
+// keyed by the name of the file they are rendered to.
+func (d *document) images() (map[string][]byte, error) {
+	images := make(map[string][]byte)
+	for _, r := range d.events {
+		for i, e := range r {
+			if e.Stream != "image" {
+				continue
+			}
+			name, err := d.imageName(e, i, len(r))
+			if err != nil {
+				return nil, err
+			}
+			images[name], _, err = decodeImage(e)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return images, nil
+}
+
+// writeImages writes the document's images relative to the directory
+// dir holding the rendered document.
+func writeImages(dir string, doc *document) error {
+	images, err := doc.images()
+	if err != nil {
+		return err
+	}
+	if doc.imageDir != "" && len(images) != 0 {
+		err = os.MkdirAll(filepath.Join(dir, doc.imageDir), 0777)
+		if err != nil {
+			return err
+		}
+	}
+	for name, data := range images {
+		err = ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), data, 0666)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
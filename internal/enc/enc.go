@@ -6,6 +6,7 @@
 package enc
 
 import (
+	"log"
 	"path"
 	"runtime"
 )
@@ -21,6 +22,27 @@ func Encode(e Event, depth int) error {
 	return render(e)
 }
 
+// BuildInfoStream is the stream of the event holding the JSON encoded
+// BuildInfo of a program. The event is emitted when the program starts.
+const BuildInfoStream = "buildinfo"
+
+// BuildInfo describes how a program was built.
+type BuildInfo struct {
+	GoVersion string   `json:"go"`
+	GOOS      string   `json:"goos"`
+	GOARCH    string   `json:"goarch"`
+	Main      Module   `json:"main"`
+	Deps      []Module `json:"deps,omitempty"`
+}
+
+// Module is a module dependency of a program.
+type Module struct {
+	Path    string  `json:"path"`
+	Version string  `json:"version"`
+	Sum     string  `json:"sum,omitempty"`
+	Replace *Module `json:"replace,omitempty"`
+}
+
 type Event struct {
 	Stream string `json:"stream"`
 	File   string `json:"file"`
@@ -29,4 +51,41 @@ type Event struct {
 	Text   string `json:"text"`
 	Image  string `json:"image,omitempty"`
 	Title  string `json:"title,omitempty"`
+	Lang   string `json:"lang,omitempty"`
+
+	// Clock holds the start and end offsets in Text
+	// of the date and time written in a log header.
+	Clock []int `json:"clock,omitempty"`
+}
+
+// LogClock returns the start and end offsets of the date and time in
+// the header of a log entry written by a log.Logger with the given
+// flags and prefix, or nil if the header holds no date or time.
+func LogClock(flag int, prefix string) []int {
+	var n int
+	if flag&log.Ldate != 0 {
+		n += len("2009/01/23 ")
+	}
+	if flag&(log.Ltime|log.Lmicroseconds) != 0 {
+		n += len("01:23:23 ")
+		if flag&log.Lmicroseconds != 0 {
+			n += len(".123123")
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	var start int
+	if flag&log.Lmsgprefix == 0 {
+		start = len(prefix)
+	}
+	return []int{start, start + n}
 }
+
+// ParamsEnv is the environment variable holding the JSON encoded
+// map of document parameter names to values.
+const ParamsEnv = "GD_PARAMS"
+
+// ExamplesEnv is the environment variable that is set when a test
+// binary is run to render the examples of a Go test source.
+const ExamplesEnv = "GD_EXAMPLES"
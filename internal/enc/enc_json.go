@@ -8,7 +8,10 @@ package enc
 
 import (
 	"encoding/json"
+	"io"
 	"os"
+	"runtime"
+	"runtime/debug"
 	"sync"
 )
 
@@ -18,7 +21,51 @@ var (
 )
 
 func init() {
-	enc := json.NewEncoder(os.Stdout)
+	stdout := os.Stdout
+	examples := os.Getenv(ExamplesEnv) != ""
+	enc := json.NewEncoder(stdout)
 	enc.SetEscapeHTML(false)
-	render = enc.Encode
+	render = func(v interface{}) error {
+		err := enc.Encode(v)
+		if err != nil {
+			return err
+		}
+		// The testing package replaces os.Stdout to capture
+		// the output of examples, so when gd runs examples
+		// echo stdout text there for it to be checked against
+		// the output comment.
+		e, ok := v.(Event)
+		if ok && examples && e.Stream == "stdout" && os.Stdout != stdout {
+			_, err = io.WriteString(os.Stdout, e.Text)
+		}
+		return err
+	}
+
+	info := BuildInfo{
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if ok {
+		info.Main = module(&bi.Main)
+		for _, m := range bi.Deps {
+			info.Deps = append(info.Deps, module(m))
+		}
+	}
+	text, err := json.Marshal(info)
+	if err != nil {
+		panic(err)
+	}
+	render(Event{Stream: BuildInfoStream, Text: string(text)})
+}
+
+// module returns the Module described by m.
+func module(m *debug.Module) Module {
+	mod := Module{Path: m.Path, Version: m.Version, Sum: m.Sum}
+	if m.Replace != nil {
+		r := module(m.Replace)
+		mod.Replace = &r
+	}
+	return mod
 }
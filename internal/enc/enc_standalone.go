@@ -29,8 +29,10 @@ func render(e Event) error {
 		fmt.Print(e.Text)
 	case "stderr":
 		fmt.Fprint(os.Stderr, e.Text)
-	case "markdown":
+	case "markdown", "code":
 		fmt.Print(e.Text)
+	case "stdin":
+		// Input is echoed by the terminal.
 	case "image":
 		var (
 			src    io.Reader
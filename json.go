@@ -0,0 +1,248 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/kortschak/gd/internal/enc"
+)
+
+// model is the machine-readable document model rendered
+// by the json output format.
+type model struct {
+	// Source is the path to the rendered source file.
+	Source string `json:"source"`
+	// Args are the command line arguments passed
+	// to the program.
+	Args []string `json:"args"`
+	// Command is the gd command line that generated
+	// the document.
+	Command string `json:"command"`
+
+	// Params holds the document parameters passed
+	// to the program.
+	Params map[string]string `json:"params,omitempty"`
+
+	// Chunks is the ordered list of document chunks.
+	Chunks []chunk `json:"chunks"`
+
+	// Halted describes the limit that stopped the
+	// program before it completed, if any.
+	Halted string `json:"halted,omitempty"`
+
+	// Provenance describes how the output was
+	// produced, if requested.
+	Provenance *provenance `json:"provenance,omitempty"`
+}
+
+// chunk is a section of a rendered document.
+type chunk struct {
+	// Kind is the kind of the chunk, one of "code",
+	// "prose" or "output".
+	Kind string `json:"kind"`
+
+	// Start and End are the first and last source
+	// lines of a code or prose chunk, and the line
+	// the output follows for an output chunk.
+	Start int `json:"start"`
+	End   int `json:"end"`
+
+	// Text is the text of a code or prose chunk.
+	Text string `json:"text,omitempty"`
+
+	// Events holds the output events of an
+	// output chunk.
+	Events []output `json:"events,omitempty"`
+}
+
+// output is a single output event.
+type output struct {
+	// Stream is the event stream, one of "stdin",
+	// "stdout", "stderr", "markdown", "code" or
+	// "image".
+	// The text of a stdin event is the input
+	// consumed by a scanning call.
+	Stream string `json:"stream"`
+	// Line is the source line of the call that
+	// generated the event.
+	Line int `json:"line"`
+
+	// Text is the output text, or the alt text
+	// of an image.
+	Text string `json:"text"`
+
+	// Image is the name of the file an image was
+	// written to, or its data URI if images are
+	// rendered inline.
+	Image string `json:"image,omitempty"`
+	// Title is the title of an image.
+	Title string `json:"title,omitempty"`
+	// Lang is the language of a code event.
+	Lang string `json:"lang,omitempty"`
+}
+
+// chunks returns the ordered chunks of the document. Images are
+// referenced by file name unless inline is true.
+func (d *document) chunks(inline bool) ([]chunk, error) {
+	var (
+		chunks []chunk
+		err    error
+	)
+	switch {
+	case d.blocks != nil:
+		chunks, err = d.markdownChunks(inline)
+	case d.examples != nil:
+		chunks, err = d.exampleChunks(inline)
+	default:
+		chunks, err = d.goChunks(inline)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d.halted != "" {
+		var last int
+		if len(chunks) != 0 {
+			last = chunks[len(chunks)-1].End
+		}
+		h := d.haltEvent()
+		chunks = append(chunks, chunk{
+			Kind:   "output",
+			Start:  last,
+			End:    last,
+			Events: []output{{Stream: h.Stream, Text: h.Text}},
+		})
+	}
+	return chunks, nil
+}
+
+// goChunks returns the ordered chunks of a Go source document.
+// Images are referenced by file name unless inline is true.
+func (d *document) goChunks(inline bool) ([]chunk, error) {
+	var (
+		chunks []chunk
+		code   *chunk
+	)
+	flush := func() {
+		if code != nil {
+			chunks = append(chunks, *code)
+			code = nil
+		}
+	}
+	addOutput := func(line int) error {
+		r, ok := d.events[line]
+		if !ok {
+			return nil
+		}
+		flush()
+		events, err := d.outputs(r, inline)
+		if err != nil {
+			return err
+		}
+		chunks = append(chunks, chunk{Kind: "output", Start: line, End: line, Events: events})
+		return nil
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(d.src))
+	var line int
+	for sc.Scan() {
+		err := addOutput(line)
+		if err != nil {
+			return nil, err
+		}
+		line++
+		c, ok := d.mdText[line]
+		if !ok {
+			if code == nil {
+				code = &chunk{Kind: "code", Start: line}
+			}
+			code.End = line
+			code.Text += sc.Text() + "\n"
+			continue
+		}
+		flush()
+		end := d.fset.Position(c.End()).Line
+		chunks = append(chunks, chunk{
+			Kind:  "prose",
+			Start: line,
+			End:   end,
+			Text:  strings.TrimPrefix(d.prose(c), "\n"),
+		})
+		err = skip(end-line, sc)
+		if err != nil {
+			return nil, err
+		}
+		line = end
+	}
+	if sc.Err() != nil {
+		return nil, sc.Err()
+	}
+	err := addOutput(line)
+	if err != nil {
+		return nil, err
+	}
+	flush()
+	return chunks, nil
+}
+
+// outputs returns the output events for the events in r, all from a
+// single source line. Images are referenced by file name unless
+// inline is true.
+func (d *document) outputs(r []enc.Event, inline bool) ([]output, error) {
+	events := make([]output, 0, len(r))
+	for i, e := range r {
+		o := output{
+			Stream: e.Stream,
+			Line:   e.Line,
+			Text:   e.Text,
+			Title:  e.Title,
+			Lang:   e.Lang,
+		}
+		if e.Stream == "image" {
+			if inline {
+				o.Image = e.Image
+			} else {
+				name, err := d.imageName(e, i, len(r))
+				if err != nil {
+					return nil, err
+				}
+				o.Image = name
+			}
+		}
+		events = append(events, o)
+	}
+	return events, nil
+}
+
+// renderJSON renders the document to out as a JSON document model
+// generated by the gd command line, command, with the document
+// parameters, params, including the provenance p if it is not nil.
+func renderJSON(out io.Writer, doc *document, command []string, params map[string]string, p *provenance, inline bool) error {
+	chunks, err := doc.chunks(inline)
+	if err != nil {
+		return err
+	}
+	m := model{
+		Source:  doc.path,
+		Args:    doc.args,
+		Command: formatCLargs(command),
+		Params:  params,
+		Chunks:  chunks,
+		Halted:  doc.halted,
+
+		Provenance: p,
+	}
+	if m.Args == nil {
+		m.Args = []string{}
+	}
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	return enc.Encode(m)
+}
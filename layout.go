@@ -0,0 +1,122 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// renderSideBySide renders the document to out as Markdown with each
+// code chunk and the output that follows it laid out in the left and
+// right columns of an HTML table. Prose is rendered between tables.
+func renderSideBySide(out io.Writer, doc *document, inline bool) error {
+	chunks, err := doc.chunks(inline)
+	if err != nil {
+		return err
+	}
+	ticks := doc.fence()
+
+	var inTable bool
+	for i := 0; i < len(chunks); i++ {
+		c := chunks[i]
+		if c.Kind == "prose" {
+			if inTable {
+				_, err = fmt.Fprint(out, "</table>\n\n")
+				if err != nil {
+					return err
+				}
+				inTable = false
+			}
+			_, err = fmt.Fprintf(out, "%s\n", c.Text)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !inTable {
+			_, err = fmt.Fprint(out, "<table>\n")
+			if err != nil {
+				return err
+			}
+			inTable = true
+		}
+		_, err = fmt.Fprint(out, "<tr>\n<td>\n\n")
+		if err != nil {
+			return err
+		}
+		if c.Kind == "code" {
+			_, err = fmt.Fprintf(out, "%sgo\n%s%s\n", ticks, c.Text, ticks)
+			if err != nil {
+				return err
+			}
+			if i+1 >= len(chunks) || chunks[i+1].Kind != "output" {
+				// Code without output, such as the
+				// closing brace of a function, has
+				// no output cell.
+				_, err = fmt.Fprint(out, "\n</td>\n</tr>\n")
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			i++
+			c = chunks[i]
+		}
+		_, err = fmt.Fprint(out, "\n</td>\n<td>\n\n")
+		if err != nil {
+			return err
+		}
+		err = renderOutputCell(out, c.Events, ticks)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprint(out, "\n</td>\n</tr>\n")
+		if err != nil {
+			return err
+		}
+	}
+	if inTable {
+		_, err = fmt.Fprint(out, "</table>\n")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderOutputCell renders the output events of a single table cell.
+func renderOutputCell(out io.Writer, events []output, ticks string) error {
+	for i, e := range events {
+		if i != 0 {
+			_, err := fmt.Fprintln(out)
+			if err != nil {
+				return err
+			}
+		}
+		var err error
+		switch e.Stream {
+		case "stdin", "stdout", "stderr", "code":
+			if !strings.HasSuffix(e.Text, "\n") {
+				e.Text += "\n"
+			}
+			_, err = fmt.Fprintf(out, "%s%s\n%s%s\n", ticks, fenceInfo(e.Stream, e.Lang), e.Text, ticks)
+		case "markdown":
+			_, err = fmt.Fprint(out, e.Text)
+		case "image":
+			if e.Title == "" {
+				_, err = fmt.Fprintf(out, "![%s](%s)\n", e.Text, e.Image)
+			} else {
+				_, err = fmt.Fprintf(out, "![%s](%s %q)\n", e.Text, e.Image, e.Title)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kortschak/gd/internal/enc"
+)
+
+// byteSize is a flag.Value holding a size in bytes. Sizes are
+// written as an integer with an optional B, KiB, MiB, GiB or TiB
+// suffix, as for GOMEMLIMIT.
+type byteSize int64
+
+var sizeUnits = []struct {
+	suffix string
+	scale  int64
+}{
+	{"TiB", 1 << 40},
+	{"GiB", 1 << 30},
+	{"MiB", 1 << 20},
+	{"KiB", 1 << 10},
+	{"B", 1},
+}
+
+func (s byteSize) String() string {
+	if s == 0 {
+		return "0"
+	}
+	for _, u := range sizeUnits {
+		if int64(s)%u.scale == 0 {
+			return fmt.Sprintf("%d%s", int64(s)/u.scale, u.suffix)
+		}
+	}
+	panic("unreachable")
+}
+
+func (s *byteSize) Set(v string) error {
+	scale := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(v, u.suffix) {
+			v = strings.TrimSuffix(v, u.suffix)
+			scale = u.scale
+			break
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 || n > (1<<63-1)/scale {
+		return fmt.Errorf("invalid size: %q", v)
+	}
+	*s = byteSize(n * scale)
+	return nil
+}
+
+// limiter is a shared limit on the number of bytes written to a set
+// of writers. When the limit is exceeded, excess output is discarded
+// and the exceeded channel is closed.
+type limiter struct {
+	mu       sync.Mutex
+	max      int64
+	used     int64
+	exceeded chan struct{}
+}
+
+// newLimiter returns a limiter allowing n bytes to be written. There
+// is no limit if n is zero.
+func newLimiter(n int64) *limiter {
+	return &limiter{max: n, exceeded: make(chan struct{})}
+}
+
+// take uses n bytes of the limit and returns the number of the bytes
+// that are within the limit.
+func (l *limiter) take(n int64) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.max == 0 {
+		return n
+	}
+	if l.used > l.max {
+		return 0
+	}
+	if l.used+n > l.max {
+		n = l.max - l.used
+		l.used = l.max + 1
+		close(l.exceeded)
+		return n
+	}
+	l.used += n
+	return n
+}
+
+// writer returns a writer to w that is subject to the limit.
+func (l *limiter) writer(w io.Writer) io.Writer {
+	return limitedWriter{l: l, w: w}
+}
+
+type limitedWriter struct {
+	l *limiter
+	w io.Writer
+}
+
+func (w limitedWriter) Write(p []byte) (int, error) {
+	n := w.l.take(int64(len(p)))
+	if n == 0 {
+		return len(p), nil
+	}
+	_, err := w.w.Write(p[:n])
+	return len(p), err
+}
+
+// eventWriter returns a writer to w of a program's event stream that
+// is subject to the limit. Only the text and image data of events are
+// counted, so the encoding of the stream and the build information
+// event do not use the limit. Lines that are not events are counted in
+// full. An event that exceeds the limit is discarded.
+func (l *limiter) eventWriter(w io.Writer) *eventWriter {
+	return &eventWriter{l: l, w: w}
+}
+
+type eventWriter struct {
+	l       *limiter
+	w       io.Writer
+	partial []byte
+}
+
+func (w *eventWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		err := w.writeLine(w.partial[:i+1])
+		w.partial = w.partial[i+1:]
+		if err != nil {
+			return len(p), err
+		}
+	}
+}
+
+// Flush writes a final unterminated line.
+func (w *eventWriter) Flush() error {
+	if len(w.partial) == 0 {
+		return nil
+	}
+	err := w.writeLine(w.partial)
+	w.partial = nil
+	return err
+}
+
+func (w *eventWriter) writeLine(l []byte) error {
+	n := eventSize(l)
+	if w.l.take(n) < n {
+		return nil
+	}
+	_, err := w.w.Write(l)
+	return err
+}
+
+// eventSize returns the number of bytes of the line l of an event
+// stream that count towards an output limit.
+func eventSize(l []byte) int64 {
+	if !bytes.HasPrefix(l, []byte("{")) {
+		return int64(len(l))
+	}
+	var e enc.Event
+	err := json.Unmarshal(l, &e)
+	if err != nil || e.Stream == "" {
+		return int64(len(l))
+	}
+	if e.Stream == enc.BuildInfoStream {
+		return 0
+	}
+	return int64(len(e.Text) + len(e.Image))
+}
+
+// oomWatcher is a writer that records whether the Go runtime's
+// out of memory fatal error has been written to it.
+type oomWatcher struct {
+	mu   sync.Mutex
+	tail []byte
+	oom  bool
+}
+
+const oomMessage = "fatal error: runtime: out of memory"
+
+func (w *oomWatcher) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.oom {
+		return len(p), nil
+	}
+	// Keep enough of the previous write to find a
+	// message that spans writes.
+	w.tail = append(w.tail, p...)
+	w.oom = bytes.Contains(w.tail, []byte(oomMessage))
+	if len(w.tail) > len(oomMessage) {
+		w.tail = append(w.tail[:0], w.tail[len(w.tail)-len(oomMessage):]...)
+	}
+	return len(p), nil
+}
+
+func (w *oomWatcher) exceeded() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.oom
+}
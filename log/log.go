@@ -128,6 +128,7 @@ func output(calldepth int, s string) error {
 	e := enc.Event{
 		Stream: w.stream,
 		Text:   w.text(),
+		Clock:  enc.LogClock(std.Flags(), std.Prefix()),
 	}
 	return enc.Encode(e, 2)
 }
@@ -250,7 +251,7 @@ func SetOutput(w io.Writer) {
 
 // SetPrefix sets the output prefix for the standard logger.
 func SetPrefix(prefix string) {
-	log.SetPrefix(prefix)
+	std.SetPrefix(prefix)
 }
 
 // Writer returns the output destination for the standard logger.
@@ -7,9 +7,7 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
-	"encoding/base64"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -23,368 +21,882 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 
 	"github.com/kortschak/gd/internal/enc"
 )
 
 func main() {
-	inline := flag.Bool("inline", false, "render images as inline data: URIs")
-	notice := flag.Bool("notice", true, "prefix file with code generation notice")
-	quote := flag.Bool("quote", true, "quote output chunks")
-	target := flag.String("o", "", "specify output file (stdout if empty")
+	var opts options
+	flag.BoolVar(&opts.inline, "inline", false, "render images as inline data: URIs")
+	flag.BoolVar(&opts.notice, "notice", true, "prefix file with code generation notice")
+	flag.BoolVar(&opts.quote, "quote", true, "quote output chunks")
+	flag.StringVar(&opts.format, "format", "markdown", "output format (markdown or json)")
+	flag.StringVar(&opts.layout, "layout", "inline", "markdown output layout (inline or side-by-side)")
+	flag.StringVar(&opts.cache, "cache", defaultCacheDir(), "directory for cached program results (no caching if empty)")
+	flag.BoolVar(&opts.force, "force", false, "run the program even if a cached result is available")
+	flag.StringVar(&opts.events, "events", "", "render from this saved event stream instead of running the program")
+	save := flag.String("save-events", "", "save the program's event stream to this file")
+	flag.StringVar(&opts.output, "o", "", "specify output file (stdout if empty")
+	flag.StringVar(&opts.images, "images", "", "directory relative to the output file to write images to")
+	flag.DurationVar(&opts.timeout, "timeout", 0, "time limit for running the program (no limit if zero)")
+	flag.Var(&opts.env, "env", "set an environment variable for building and running the program, in key=value form (repeatable)")
+	flag.Var(&opts.tags, "tags", "additional build tag for the program (repeatable)")
+	buildFlags := flag.String("buildflags", "", "space-separated flags passed to go build, quoted as for a shell, e.g. \"-race -ldflags='-s -w'\"")
+	flag.StringVar(&opts.stdin, "stdin", "", "file to use as the program's standard input")
+	flag.StringVar(&opts.normalize, "normalize", "", "comma-separated output normalizers to apply: clock, pointers, tempdir or all")
+	flag.Var(&opts.replace, "replace", "replace output text matching a regular expression, written as /pattern/replacement/ (repeatable)")
+	flag.Var(&opts.paramFlags, "param", "set a document parameter, in name=value form (repeatable)")
+	sweepFlags := stringList{}
+	flag.Var(&sweepFlags, "sweep", "render one document per parameter value, in name=value,value,... form (repeatable; requires -o)")
+	flag.BoolVar(&opts.provenance, "provenance", false, "append a provenance block describing the toolchain, modules, arguments and source")
+	flag.Var(&opts.memlimit, "memlimit", "memory limit for the program, e.g. 512MiB (no limit if zero)")
+	flag.Var(&opts.outlimit, "outlimit", "limit on the size of the program's output, e.g. 10MiB (no limit if zero)")
+	bundle := flag.String("txtar", "", "write a txtar archive of the source, inputs and output to this file")
+	into := flag.String("into", "", "update the gd:begin/gd:end marked regions of this Markdown file in place")
+	stale := flag.Bool("check", false, "check that the -o output file and its images are up to date")
+	poll := flag.Duration("watch", 0, "re-render when the source changes, polling at this interval (e.g. 500ms)")
+	addr := flag.String("serve", "", "serve a live HTML preview on this address (e.g. :8080)")
+	all := flag.String("all", "", "render every gd source matching this pattern (e.g. ./...) to the -o file name in its directory")
+	jobs := flag.Int("j", runtime.NumCPU(), "maximum number of parallel renderings with -all")
+	update := flag.Bool("update", false, "rewrite output expectations in the source that do not match the output")
 	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s: %[1]s [options] <src.go>\n\nOptions:\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s: %[1]s [options] <src.go|src.md>\n\nOptions:\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 	flag.Parse()
 
-	out := io.Writer(os.Stdout)
-	if *target != "" {
-		f, err := os.Create(*target)
+	axes, err := parseSweep(sweepFlags)
+	if err == nil {
+		opts.params, err = parseParams(opts.paramFlags)
+	}
+	if err == nil {
+		opts.buildFlags, err = splitQuoted(*buildFlags)
+	}
+	if err != nil {
+		fmt.Fprintln(flag.CommandLine.Output(), err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	opts.flags = make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		opts.flags[f.Name] = true
+	})
+	err = opts.validate()
+	if err != nil {
+		fmt.Fprintln(flag.CommandLine.Output(), err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *all != "" {
+		sources, err := findSources(*all)
 		if err != nil {
+			log.Fatal(err)
+		}
+		if renderAll(os.Stdout, sources, *jobs, opts) != 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *into != "" {
+		if opts.format != "markdown" {
 			flag.Usage()
 			os.Exit(2)
 		}
-		defer f.Close()
-		out = f
+		opts.notice = false
+		opts.fragment = true
+		err := updateRegions(*into, flag.Args(), opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
 	if len(flag.Args()) < 1 {
 		flag.Usage()
 		os.Exit(2)
 	}
-	src, err := ioutil.ReadFile(flag.Arg(0))
+	opts, err = opts.withConfig(flag.Arg(0))
 	if err != nil {
 		log.Fatal(err)
 	}
-	fset := token.NewFileSet()
-	f, err := parser.ParseFile(fset, flag.Arg(0), src, parser.ParseComments)
+	target := opts.target(flag.Arg(0))
+	if *stale && target == "" || len(axes) != 0 && (target == "" || *stale || *poll > 0 || *addr != "") || *update && (*poll > 0 || *addr != "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *addr != "" {
+		interval := *poll
+		if interval <= 0 {
+			interval = 500 * time.Millisecond
+		}
+		err := serve(*addr, flag.Arg(0), flag.Args()[1:], opts, interval)
+		log.Fatal(err)
+	}
+	if len(axes) != 0 {
+		err = sweep(flag.Arg(0), flag.Args()[1:], target, axes, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *poll > 0 {
+		watch(flag.Arg(0), flag.Args()[1:], target, opts, *poll)
+	}
+	doc, err := load(flag.Arg(0), flag.Args()[1:], opts)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	// Replace the "fmt" and "show" imports with our hooks.
-	for _, decl := range f.Decls {
-		gen, ok := decl.(*ast.GenDecl)
-		if ok && gen.Tok == token.IMPORT {
-			for _, spec := range gen.Specs {
-				imp := spec.(*ast.ImportSpec)
-				switch imp.Path.Value {
-				case `"fmt"`:
-					imp.Path.Value = `"github.com/kortschak/gd/fmt"`
-				case `"log"`:
-					imp.Path.Value = `"github.com/kortschak/gd/log"`
-				case `"show"`:
-					imp.Path.Value = `"github.com/kortschak/gd/show"`
-
-				}
+	if doc.halted != "" {
+		log.Printf("%s: %s", flag.Arg(0), doc.halted)
+	}
+	if *update {
+		updated, err := doc.updateExpectations()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if updated {
+			// Render the updated source.
+			doc, err = load(flag.Arg(0), flag.Args()[1:], opts)
+			if err != nil {
+				log.Fatal(err)
 			}
 		}
 	}
+	if *save != "" {
+		err = saveEvents(*save, doc)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	// Find C-style comments with leading {md} mark.
-	mdText := make(map[int]*ast.Comment)
-	for _, c := range f.Comments {
-		for _, l := range c.List {
-			if strings.HasPrefix(l.Text, "/*{md}\n") {
-				mdText[fset.Position(l.Pos()).Line] = l
-			}
+	if *stale {
+		ok, err := check(os.Stdout, target, doc, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if doc.reportMismatches(os.Stderr) != 0 {
+			ok = false
 		}
+		if len(doc.failed) != 0 {
+			fmt.Fprintf(os.Stderr, "%s: output mismatch: %s\n", flag.Arg(0), strings.Join(doc.failed, ", "))
+			ok = false
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
 	}
 
-	events, err := run(fset, f, flag.Args()[1:])
+	out := io.Writer(os.Stdout)
+	if target != "" {
+		f, err := os.Create(target)
+		if err != nil {
+			flag.Usage()
+			os.Exit(2)
+		}
+		defer f.Close()
+		out = f
+	}
+	err = opts.render(out, doc, target)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	longTicks := longestTicks(string(src))
-	for _, grp := range events {
-		for _, e := range grp {
-			n := longestTicks(e.Text)
-			if n > longTicks {
-				longTicks = n
-			}
+	if !opts.inline {
+		err = writeImages(imageBase(target), doc)
+		if err != nil {
+			log.Fatal(err)
 		}
 	}
-	ticks := strings.Repeat("`", max(longTicks+1, 3))
 
-	if *notice {
-		_, err = fmt.Fprintf(out, "<!-- Code generated by `%v`; DO NOT EDIT. -->\n", formatCLargs(os.Args))
+	if *bundle != "" {
+		err = writeTxtar(*bundle, doc, opts)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
+	if len(doc.failed) != 0 {
+		log.Fatalf("%s: output mismatch: %s", flag.Arg(0), strings.Join(doc.failed, ", "))
+	}
+	if doc.reportMismatches(os.Stderr) != 0 {
+		os.Exit(1)
+	}
+}
+
+// options holds document running and rendering options.
+type options struct {
+	inline bool
+	notice bool
+	quote  bool
+	format string
+	layout string
+	// fragment is whether the output is part of a
+	// larger document, such as a region updated by
+	// -into or a served page, rather than a whole
+	// document. Fragments have no front matter.
+	fragment bool
+	// normalize is the comma-separated list of
+	// built-in normalizers applied to output text.
+	normalize string
+	// replace holds output replacement rules in
+	// /pattern/replacement/ form.
+	replace stringList
+	// params holds the document parameters passed
+	// to the program.
+	params map[string]string
+	// paramFlags holds the parameters set on the
+	// command line in name=value form.
+	paramFlags stringList
+	// provenance specifies that a block describing
+	// how the output was produced is rendered.
+	provenance bool
+
+	// output is the output file name. If the output
+	// is set by a configuration file, it is relative
+	// to the directory holding the source.
+	output string
+	// images is the directory relative to the output
+	// file that images are written to.
+	images string
+
+	// args are the default program arguments.
+	args []string
+	// stdin is the path of the file to use as the
+	// program's standard input. If stdin is set by
+	// a configuration file, it is relative to the
+	// directory holding the source.
+	stdin string
+	// env holds additional environment variables
+	// for building and running the program in
+	// "key=value" form.
+	env stringList
+	// tags are additional build tags for the
+	// program.
+	tags stringList
+	// buildFlags are additional go build flags.
+	buildFlags []string
+	// timeout is the time limit for running the
+	// program. There is no limit if timeout is zero.
+	timeout time.Duration
+	// memlimit is the memory limit for the program.
+	// There is no limit if memlimit is zero.
+	memlimit byteSize
+	// outlimit is the limit on the total size of
+	// the text and image data written by the
+	// program and its stderr. There is no limit
+	// if outlimit is zero.
+	outlimit byteSize
+
+	// cache is the directory holding cached
+	// program results. Results are not cached
+	// if cache is empty.
+	cache string
+	// force specifies that programs are run
+	// even if a cached result is available.
+	force bool
+
+	// events is the path to a saved event stream
+	// to render instead of running the program.
+	events string
+
+	// flags holds the names of options set on the
+	// command line. These take precedence over
+	// configuration files.
+	flags map[string]bool
+	// configArgs holds the command line flags
+	// equivalent to the build and environment
+	// settings taken from configuration files.
+	configArgs []string
+}
+
+// validate returns an error if o holds invalid option values.
+func (o options) validate() error {
+	switch o.format {
+	case "markdown", "json":
+	default:
+		return fmt.Errorf("invalid format: %q", o.format)
+	}
+	switch o.layout {
+	case "inline", "side-by-side":
+	default:
+		return fmt.Errorf("invalid layout: %q", o.layout)
+	}
+	_, err := newNormalizer(o.normalize, o.replace)
+	return err
+}
+
+// target returns the path of the output file for the source at path,
+// or the empty string if output is to stdout.
+func (o options) target(path string) string {
+	if o.output == "" || o.flags["o"] {
+		return o.output
+	}
+	return filepath.Join(filepath.Dir(path), o.output)
+}
 
-	rep := strings.NewReplacer("\n", "\n> ")
-	sc := bufio.NewScanner(bytes.NewReader(src))
-	var line int
-	wasComment := true
-	for sc.Scan() {
-		r, ok := events[line]
-		if ok {
-			_, err = fmt.Fprintln(out, ticks)
+// imageBase returns the directory that image paths are relative to
+// for the output file target.
+func imageBase(target string) string {
+	if target == "" {
+		return "."
+	}
+	return filepath.Dir(target)
+}
+
+// render renders the document to out. The target is the path of the
+// output file, or the empty string if the output is not written to a
+// file, and is used to make paths in the recorded command relative to
+// the output.
+func (o options) render(out io.Writer, doc *document, target string) error {
+	switch o.format {
+	case "markdown":
+		if len(o.params) != 0 && !o.fragment {
+			err := renderFrontMatter(out, o.params)
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
-			for i, e := range r {
-				if e.File != flag.Arg(0) {
-					log.Fatalf("called event generator in dependency file: %s:%d", e.File, e.Line)
-				}
-				switch e.Stream {
-				case "stdout", "stderr":
-					if !strings.HasSuffix(e.Text, "\n") {
-						e.Text += "\n"
-					}
-					if *quote {
-						_, err = fmt.Fprintf(out, "> %s%s\n> %s%s\n", ticks, e.Stream, rep.Replace(e.Text), ticks)
-						if err != nil {
-							log.Fatal(err)
-						}
-					} else {
-						_, err = fmt.Fprintf(out, "%s%s\n%s%s\n", ticks, e.Stream, e.Text, ticks)
-						if err != nil {
-							log.Fatal(err)
-						}
-					}
-				case "markdown":
-					_, err = fmt.Fprint(out, e.Text)
-					if err != nil {
-						log.Fatal(err)
-					}
-				case "image":
-					if *inline {
-						e.Image = strings.TrimPrefix(e.Image, "data:image/svg+xml,")
-						if e.Title == "" {
-							_, err = fmt.Fprintf(out, "![%s](%s)\n\n", e.Text, e.Image)
-							if err != nil {
-								log.Fatal(err)
-							}
-						} else {
-							_, err = fmt.Fprintf(out, "![%s](%s %q)\n\n", e.Text, e.Image, e.Title)
-							if err != nil {
-								log.Fatal(err)
-							}
-						}
-					} else {
-						var (
-							src    io.Reader
-							format string
-						)
-						switch {
-						case strings.HasPrefix(e.Image, "data:image/jpeg;base64,"):
-							data := strings.TrimPrefix(e.Image, "data:image/jpeg;base64,")
-							src = base64.NewDecoder(base64.StdEncoding, strings.NewReader(data))
-							format = "jpeg"
-						case strings.HasPrefix(e.Image, "data:image/png;base64,"):
-							data := strings.TrimPrefix(e.Image, "data:image/png;base64,")
-							src = base64.NewDecoder(base64.StdEncoding, strings.NewReader(data))
-							format = "png"
-						case strings.HasPrefix(e.Image, "data:image/svg+xml,"):
-							data := strings.TrimPrefix(e.Image, "data:image/svg+xml,")
-							src = strings.NewReader(data)
-							format = "svg"
-						default:
-							log.Fatalf("unknown image format: %s", e.Image)
-						}
-						var name string
-						base := filepath.Base(e.File)
-						ext := filepath.Ext(base)
-						base = base[:len(base)-len(ext)]
-						if len(r) == 1 {
-							name = fmt.Sprintf("%s_%d.%s", base, e.Line, format)
-						} else {
-							name = fmt.Sprintf("%s_%d_%d.%s", base, e.Line, i, format)
-						}
-
-						dst, err := os.Create(name)
-						if err != nil {
-							log.Fatal(err)
-						}
-						_, err = io.Copy(dst, src)
-						if err != nil {
-							log.Fatal(err)
-						}
-						err = dst.Close()
-						if err != nil {
-							log.Fatal(err)
-						}
-						if *quote {
-							_, err = fmt.Fprint(out, "> ")
-							if err != nil {
-								log.Fatal(err)
-							}
-						}
-						if e.Title == "" {
-							_, err = fmt.Fprintf(out, "![%s](%s)\n", e.Text, name)
-							if err != nil {
-								log.Fatal(err)
-							}
-						} else {
-							_, err = fmt.Fprintf(out, "![%s](%s %q)\n", e.Text, name, e.Title)
-							if err != nil {
-								log.Fatal(err)
-							}
-						}
-						if len(r) != 1 && i != len(r)-1 {
-							_, err = fmt.Fprintln(out)
-							if err != nil {
-								log.Fatal(err)
-							}
-						}
-					}
-				}
-			}
-			_, err = fmt.Fprintln(out, ticks)
+		}
+		if o.notice {
+			_, err := fmt.Fprintf(out, "<!-- Code generated by `%v`; DO NOT EDIT. -->\n", formatCLargs(o.command(doc, target)))
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
 		}
-		line++
-		c, ok := mdText[line]
-		if !ok {
-			if wasComment {
-				_, err = fmt.Fprintln(out, ticks)
-				if err != nil {
-					log.Fatal(err)
-				}
+		var err error
+		switch {
+		case o.layout == "side-by-side":
+			err = renderSideBySide(out, doc, o.inline)
+		case doc.blocks != nil:
+			err = renderMarkdownSource(out, doc, o.inline, o.quote)
+		case doc.examples != nil:
+			err = renderExamples(out, doc, o.inline, o.quote)
+		default:
+			err = renderMarkdown(out, doc, o.inline, o.quote)
+		}
+		if err != nil || !o.provenance {
+			return err
+		}
+		return renderProvenance(out, doc.provenance())
+	case "json":
+		var p *provenance
+		if o.provenance {
+			p = doc.provenance()
+		}
+		return renderJSON(out, doc, o.command(doc, target), o.params, p, o.inline)
+	default:
+		return fmt.Errorf("unknown format: %s", o.format)
+	}
+}
+
+// load parses the gd source at path and runs it with the
+// given arguments.
+func load(path string, args []string, opts options) (*document, error) {
+	parseSource := parse
+	switch {
+	case isMarkdown(path):
+		parseSource = parseMarkdown
+	case isTestSource(path):
+		parseSource = parseExamples
+	}
+	doc, err := parseSource(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		args = opts.args
+	}
+	doc.args = args
+	doc.imageDir = opts.images
+	if opts.normalize != "" || len(opts.replace) != 0 {
+		doc.normalizer, err = newNormalizer(opts.normalize, opts.replace)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.stdin != "" {
+		doc.stdin = opts.stdin
+		if !opts.flags["stdin"] {
+			doc.stdin = filepath.Join(filepath.Dir(path), opts.stdin)
+		}
+	}
+	err = doc.run(opts)
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// document is a gd source file and the output events collected
+// from running it.
+type document struct {
+	// path is the path to the source file.
+	path string
+	// src is the source text.
+	src []byte
+	// args are the command line arguments passed
+	// to the program.
+	args []string
+
+	fset *token.FileSet
+	file *ast.File
+
+	// mdText holds the {md} comments of the source
+	// keyed by their first line.
+	mdText map[int]*ast.Comment
+
+	// blocks holds the go fenced code blocks of a
+	// Markdown source. It is nil for Go sources.
+	blocks []block
+	// expects holds the output expectations of the
+	// source in source order.
+	expects []expectation
+	// examples holds the example functions of a Go
+	// test source. It is nil for other sources.
+	examples []example
+
+	// imageDir is the directory, relative to the
+	// rendered document, holding rendered images.
+	imageDir string
+
+	// inputs holds the paths of input files declared
+	// by //gd:input directives, relative to the source.
+	inputs []string
+	// stdin is the path of the file used as the
+	// program's standard input. It is set by the
+	// stdin option or a //gd:stdin directive.
+	stdin string
+
+	// trace holds the output events of the program
+	// in the order they were emitted, without
+	// normalization.
+	trace []enc.Event
+	// events holds the output events of the program
+	// keyed by the last line of their call.
+	events map[int][]enc.Event
+	// variant distinguishes the files of documents
+	// rendered with different parameters in a sweep.
+	variant string
+
+	// halted describes the limit that stopped the
+	// program before it completed. It is empty if
+	// the program ran to completion.
+	halted string
+	// failed holds the names of the examples whose
+	// output did not match their output comment.
+	failed []string
+	// normalizer rewrites the text of output events.
+	// Text is not rewritten if normalizer is nil.
+	normalizer *normalizer
+
+	// build describes how the program was built.
+	// It is nil if the program did not report it.
+	build *enc.BuildInfo
+}
+
+// parse reads and parses the gd source at path and rewrites its imports
+// to use the gd hook packages.
+func parse(path string) (*document, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	rewriteImports(f)
+
+	// Find C-style comments with leading {md} mark.
+	mdText := make(map[int]*ast.Comment)
+	for _, c := range f.Comments {
+		for _, l := range c.List {
+			if strings.HasPrefix(l.Text, "/*{md}\n") {
+				mdText[fset.Position(l.Pos()).Line] = l
 			}
-			wasComment = false
-			_, err = fmt.Fprintln(out, sc.Text())
-			if err != nil {
-				log.Fatal(err)
+		}
+	}
+	d := findDirectives(path, f)
+
+	return &document{
+		path:    path,
+		src:     src,
+		fset:    fset,
+		file:    f,
+		mdText:  mdText,
+		expects: findExpectations(fset, f, mdText, d.output),
+		inputs:  d.inputs,
+		stdin:   d.stdin,
+	}, nil
+}
+
+// directives holds the settings made by //gd: directives in a source.
+type directives struct {
+	// inputs are the input files named by //gd:input
+	// directives, relative to the source's directory.
+	inputs []string
+	// stdin is the path of the file named by a
+	// //gd:stdin directive.
+	stdin string
+	// output is whether a //gd:output directive makes
+	// // Output: comments expectations.
+	output bool
+}
+
+// findDirectives returns the settings made by the //gd: directives in
+// the comments of f, the source at path.
+func findDirectives(path string, f *ast.File) directives {
+	var d directives
+	for _, c := range f.Comments {
+		for _, l := range c.List {
+			switch {
+			case strings.HasPrefix(l.Text, "//gd:input "):
+				d.inputs = append(d.inputs, strings.Fields(strings.TrimPrefix(l.Text, "//gd:input "))...)
+			case strings.HasPrefix(l.Text, "//gd:stdin "):
+				d.stdin = stdinPath(path, l.Text)
+			case l.Text == "//gd:output":
+				d.output = true
 			}
-		} else {
-			text := strings.TrimPrefix(c.Text, "/*{md}")
-			text = strings.TrimSuffix(text, "*/")
-			if !wasComment {
-				_, err = fmt.Fprint(out, ticks)
-				if err != nil {
-					log.Fatal(err)
+		}
+	}
+	return d
+}
+
+// stdinPath returns the path of the file named by the //gd:stdin
+// directive d in the source at path. The name is relative to the
+// directory holding the source.
+func stdinPath(path, d string) string {
+	return filepath.Join(filepath.Dir(path), strings.TrimSpace(strings.TrimPrefix(d, "//gd:stdin ")))
+}
+
+// rewriteImports replaces the "fmt", "log" and "show" imports
+// in f with our hooks.
+func rewriteImports(f *ast.File) {
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if ok && gen.Tok == token.IMPORT {
+			for _, spec := range gen.Specs {
+				imp := spec.(*ast.ImportSpec)
+				switch imp.Path.Value {
+				case `"fmt"`:
+					imp.Path.Value = `"github.com/kortschak/gd/fmt"`
+				case `"log"`:
+					imp.Path.Value = `"github.com/kortschak/gd/log"`
+				case `"show"`:
+					imp.Path.Value = `"github.com/kortschak/gd/show"`
+
 				}
-			} else {
-				text = strings.TrimPrefix(text, "\n")
 			}
-			indent := fset.Position(c.Pos()).Column - 1
-			text = strings.Replace(text, "\n"+strings.Repeat("\t", indent), "\n", -1)
-			_, err = fmt.Fprintf(out, "%s%s\n", text, ticks)
-			if err != nil {
-				log.Fatal(err)
-			}
-			n := fset.Position(c.End()).Line - line
-			err = skip(n, sc)
+		}
+	}
+}
+
+// run runs the document's program and collects its output events.
+// If a cache directory is specified in opts, results are taken from
+// the cache when available unless opts.force is true, and are stored
+// in the cache after running.
+func (d *document) run(opts options) error {
+	if opts.events != "" {
+		return d.replay(opts.events)
+	}
+	src, err := d.program()
+	if err != nil {
+		return err
+	}
+	var (
+		key   string
+		trace []enc.Event
+		ok    bool
+	)
+	env := opts.programEnv()
+	if opts.cache != "" {
+		key, err = d.cacheKey(src, opts.buildArgs(), env)
+		if err != nil {
+			return err
+		}
+		if !opts.force {
+			trace, ok, err = cacheLoad(opts.cache, key)
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
-			line += n
-			wasComment = false
 		}
 	}
-	if !wasComment {
-		_, err = fmt.Fprintln(out, ticks)
+	if !ok {
+		if d.examples != nil {
+			trace, d.failed, d.halted, err = d.runExamples(src, env, opts)
+		} else {
+			trace, d.halted, err = run(src, d.args, env, d.stdin, opts)
+		}
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
-	}
-}
-
-func longestTicks(s string) int {
-	var m, l int
-	for _, r := range s {
-		if r != '`' {
-			if l > m {
-				m = l
+		// Results of programs stopped by a limit
+		// depend on more than their inputs, and
+		// failed examples are reported each run.
+		if opts.cache != "" && d.halted == "" && d.failed == nil {
+			err = cacheStore(opts.cache, key, trace)
+			if err != nil {
+				return err
+			}
+			err = cacheTrim(opts.cache)
+			if err != nil {
+				return err
 			}
-			l = 0
-			continue
 		}
-		l++
 	}
-	return m
+	return d.collect(trace, false)
 }
 
-func skip(n int, sc *bufio.Scanner) error {
-	for n--; sc.Scan() && n > 0; n-- {
+// collect sets the document's events from the events in trace, keying
+// them by the last line of the call that emitted them. The text of the
+// events is normalized by the document's normalizer, and trace is kept
+// unnormalized as the document's trace. If strict is true, events that
+// do not match a call in the source are reported as an error.
+func (d *document) collect(trace []enc.Event, strict bool) error {
+	// Line directives in the program are resolved
+	// relative to the working directory.
+	path, err := filepath.Abs(d.path)
+	if err != nil {
+		return err
 	}
-	if n > 0 {
-		if sc.Err() != nil {
-			return sc.Err()
+	events := make(map[int][]enc.Event)
+	cache := make(map[funcLine]int)
+	d.build = nil
+	for _, e := range d.normalizer.normalize(trace) {
+		if e.Stream == enc.BuildInfoStream {
+			var info enc.BuildInfo
+			err = json.Unmarshal([]byte(e.Text), &info)
+			if err != nil {
+				return fmt.Errorf("invalid build info: %v", err)
+			}
+			d.build = &info
+			continue
+		}
+		// Paths may be rewritten by -trimpath.
+		if file := filepath.Clean(e.File); file != filepath.Clean(d.path) && file != path {
+			return fmt.Errorf("called event generator in dependency file: %s:%d", e.File, e.Line)
 		}
-		return io.ErrUnexpectedEOF
+		line := lastLineOf(e.Func, e.Line, d.fset, d.file, cache)
+		if line == 0 && strict {
+			return fmt.Errorf("stale event: no call to %s at %s:%d", e.Func, d.path, e.Line)
+		}
+		events[line] = append(events[line], e)
 	}
+	d.trace = trace
+	d.events = events
 	return nil
 }
 
-func max(a, b int) int {
-	if a > b {
-		return a
-	}
-	return b
+// prose returns the Markdown text held in the {md} comment c with
+// the comment markers and the comment's indentation removed.
+func (d *document) prose(c *ast.Comment) string {
+	text := strings.TrimPrefix(c.Text, "/*{md}")
+	text = strings.TrimSuffix(text, "*/")
+	indent := d.fset.Position(c.Pos()).Column - 1
+	return strings.Replace(text, "\n"+strings.Repeat("\t", indent), "\n", -1)
 }
 
-// run runs the source described by fset and f and collects output events.
-func run(fset *token.FileSet, f *ast.File, args []string) (map[int][]enc.Event, error) {
+// program returns the source of the document's program with its imports
+// rewritten to use the gd hook packages.
+func (d *document) program() ([]byte, error) {
 	// Retain line numbering to be consistent with the
 	// source as given.
 	cfg := printer.Config{
 		Mode:     printer.UseSpaces | printer.TabIndent | printer.SourcePos,
 		Tabwidth: 8,
 	}
-	wd, err := os.Getwd()
+	var buf bytes.Buffer
+	err := cfg.Fprint(&buf, d.fset, d.file)
 	if err != nil {
 		return nil, err
 	}
+	return buf.Bytes(), nil
+}
+
+// run runs the program source src with the given arguments and
+// additional environment variables, reading standard input from the
+// file stdin if it is not empty, and collects output events in the
+// order they were emitted. The program is built before it is run so
+// that the time, memory and output limits specified in opts apply only
+// to the program. The program is built with the build arguments and
+// environment in opts. If the program is stopped by a limit, its process
+// group is killed and the events emitted before it was stopped are
+// returned along with a description of the limit in halted.
+func run(src []byte, args, env []string, stdin string, opts options) (events []enc.Event, halted string, err error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, "", err
+	}
 	tmp, err := ioutil.TempFile(wd, "gd-*.go")
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer os.Remove(tmp.Name())
-	err = cfg.Fprint(tmp, fset, f)
+	_, err = tmp.Write(src)
 	if err != nil {
-		return nil, err
+		tmp.Close()
+		return nil, "", err
 	}
 	err = tmp.Close()
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	args = append([]string{"run", "-tags", "gd", tmp.Name()}, args...)
-	gorun := exec.Command("go", args...)
-	var buf bytes.Buffer
-	gorun.Stdout = &buf
-	gorun.Stderr = os.Stderr
-	err = gorun.Run()
+	dir, err := ioutil.TempDir("", "gd-")
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	defer os.RemoveAll(dir)
+	bin := filepath.Join(dir, "prog")
+	gobuild := exec.Command("go", append(opts.buildArgs(), "-o", bin, tmp.Name())...)
+	if len(opts.env) != 0 {
+		gobuild.Env = append(os.Environ(), opts.env...)
+	}
+	gobuild.Stdout = os.Stderr
+	gobuild.Stderr = os.Stderr
+	err = gobuild.Run()
+	if err != nil {
+		return nil, "", err
 	}
-	dec := json.NewDecoder(&buf)
 
-	events := make(map[int][]enc.Event)
+	stdout, halted, err := execute(exec.Command(bin, args...), env, stdin, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	events, err = decodeEvents(bytes.NewReader(stdout), halted != "")
+	if err != nil {
+		return nil, "", err
+	}
+	return events, halted, nil
+}
+
+// execute runs the built program, prog, with the additional environment
+// variables, env, reading standard input from the file stdin if it is
+// not empty, and returns its standard output. The time, memory and output
+// limits specified in opts apply to the program. If the program is stopped
+// by a limit, its process group is killed and a description of the limit
+// is returned in halted. The output written before the program exited is
+// returned even if it exited with an error.
+func execute(prog *exec.Cmd, env []string, stdin string, opts options) (stdout []byte, halted string, err error) {
+	if len(env) != 0 {
+		prog.Env = append(os.Environ(), env...)
+	}
+	if stdin != "" {
+		f, err := os.Open(stdin)
+		if err != nil {
+			return nil, "", err
+		}
+		defer f.Close()
+		prog.Stdin = f
+	}
+	setProcessGroup(prog)
+	var (
+		buf bytes.Buffer
+		oom oomWatcher
+	)
+	lim := newLimiter(int64(opts.outlimit))
+	events := lim.eventWriter(&buf)
+	prog.Stdout = events
+	prog.Stderr = lim.writer(io.MultiWriter(os.Stderr, &oom))
+	err = prog.Start()
+	if err != nil {
+		return nil, "", err
+	}
+	if opts.memlimit != 0 {
+		err = limitMemory(prog.Process.Pid, int64(opts.memlimit))
+		if err != nil {
+			killProcessGroup(prog)
+			prog.Wait()
+			return nil, "", err
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- prog.Wait() }()
+	var timeout <-chan time.Time
+	if opts.timeout > 0 {
+		timer := time.NewTimer(opts.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	exited := false
+	select {
+	case err = <-done:
+		exited = true
+		if err != nil && opts.memlimit != 0 && oom.exceeded() {
+			halted = fmt.Sprintf("exceeded memory limit of %v", opts.memlimit)
+			err = nil
+		}
+	case <-timeout:
+		halted = fmt.Sprintf("timed out after %v", opts.timeout)
+	case <-lim.exceeded:
+		halted = fmt.Sprintf("exceeded output limit of %v", opts.outlimit)
+	}
+	if halted != "" && !exited {
+		killProcessGroup(prog)
+		<-done
+	}
+	if halted == "" {
+		// A line cut short by a limit is dropped.
+		ferr := events.Flush()
+		if err == nil {
+			err = ferr
+		}
+	}
+	return buf.Bytes(), halted, err
+}
+
+// decodeEvents returns the output events held in r. If halted is
+// true, a truncated final event is ignored.
+func decodeEvents(r io.Reader, halted bool) ([]enc.Event, error) {
+	var events []enc.Event
+	dec := json.NewDecoder(r)
 	for {
 		var e enc.Event
-		err = dec.Decode(&e)
+		err := dec.Decode(&e)
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
+			if halted {
+				// The last event may have been
+				// cut short by the limit.
+				break
+			}
 			return nil, err
 		}
-		line := lastLineOf(e.Func, e.Line, fset, f)
-		events[line] = append(events[line], e)
+		events = append(events, e)
 	}
 	return events, nil
 }
 
+// programEnv returns the additional environment variables for
+// running the program.
+func (o options) programEnv() []string {
+	env := o.env[:len(o.env):len(o.env)]
+	if o.memlimit != 0 {
+		env = append(env, fmt.Sprintf("GOMEMLIMIT=%d", o.memlimit))
+	}
+	if len(o.params) != 0 {
+		env = append(env, o.paramsEnv())
+	}
+	return env
+}
+
+// formatCLargs returns args as a command line, quoting arguments
+// as needed for POSIX shells.
 func formatCLargs(args []string) string {
 	var buf strings.Builder
 	for i, s := range args {
 		if i != 0 {
 			buf.Write([]byte{' '})
 		}
-		if strings.Contains(s, " ") {
-			s = `"` + s + `"`
-		}
-		fmt.Fprint(&buf, s)
+		fmt.Fprint(&buf, shellQuote(s))
 	}
 	return buf.String()
 }
@@ -393,8 +905,13 @@ func formatCLargs(args []string) string {
 // on the given line matching the selector expression in fn.
 // It is not possible to differentiate between calls to the same
 // function on the same line due to the absence of a column field
-// in runtime.Func.
-func lastLineOf(fn string, line int, fset *token.FileSet, f *ast.File) int {
+// in runtime.Func. A deferred call is reported at the line where
+// it runs, the closing brace or a return statement of the function
+// deferring it, so if no call matches and the line is within a
+// function that defers a call matching fn, the line itself is
+// returned. Otherwise zero is returned if no call matches. Results
+// are memoized in cache.
+func lastLineOf(fn string, line int, fset *token.FileSet, f *ast.File, cache map[funcLine]int) int {
 	end, ok := cache[funcLine{name: fn, line: line}]
 	if ok {
 		return end
@@ -409,17 +926,73 @@ func lastLineOf(fn string, line int, fset *token.FileSet, f *ast.File) int {
 			return true
 		}
 		pos := fset.Position(exp.Pos())
-		if pos.Line != line || fmt.Sprintf("%s.%s", ident.X, ident.Sel.Name) != fn {
+		if pos.Line != line || !isCallOf(ident, fn) {
 			return true
 		}
 		end = fset.Position(exp.End()).Line
-		cache[funcLine{name: fn, line: line}] = end
 		return false
 	})
+	if end == 0 && defersCallAt(fn, line, fset, f) {
+		end = line
+	}
+	cache[funcLine{name: fn, line: line}] = end
 	return end
 }
 
-var cache = make(map[funcLine]int)
+// defersCallAt returns whether the given line is within the body of a
+// function that defers a call matching the selector expression in fn.
+func defersCallAt(fn string, line int, fset *token.FileSet, f *ast.File) bool {
+	var found bool
+	ast.Inspect(f, func(n ast.Node) bool {
+		if found {
+			return false
+		}
+		var body *ast.BlockStmt
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			body = n.Body
+		case *ast.FuncLit:
+			body = n.Body
+		default:
+			return true
+		}
+		if body == nil || line < fset.Position(body.Lbrace).Line || fset.Position(body.Rbrace).Line < line {
+			return false
+		}
+		ast.Inspect(body, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.FuncLit:
+				// Defers in function literals run
+				// when the literal's function returns.
+				return false
+			case *ast.DeferStmt:
+				sel, ok := n.Call.Fun.(*ast.SelectorExpr)
+				if ok && isCallOf(sel, fn) {
+					found = true
+				}
+			}
+			return !found
+		})
+		// Otherwise look in function literals holding the line.
+		return !found
+	})
+	return found
+}
+
+// isCallOf returns whether the selector expression of a call, sel,
+// may be a call of the function fn. Functions are matched by package
+// and name. Methods, named in pkg.(*Type).Method or pkg.Type.Method
+// form, are matched by name since the type of the receiver is not
+// known.
+func isCallOf(sel *ast.SelectorExpr, fn string) bool {
+	if fmt.Sprintf("%s.%s", sel.X, sel.Sel.Name) == fn {
+		return true
+	}
+	if strings.Count(fn, ".") < 2 {
+		return false
+	}
+	return sel.Sel.Name == fn[strings.LastIndex(fn, ".")+1:]
+}
 
 type funcLine struct {
 	name string
@@ -0,0 +1,236 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/kortschak/gd/internal/enc"
+)
+
+// renderMarkdown renders the document to out as Markdown.
+func renderMarkdown(out io.Writer, doc *document, inline, quote bool) error {
+	ticks := doc.fence()
+	sc := bufio.NewScanner(bytes.NewReader(doc.src))
+	var line int
+	wasComment := true
+	for sc.Scan() {
+		r, ok := doc.events[line]
+		if ok {
+			_, err := fmt.Fprintln(out, ticks)
+			if err != nil {
+				return err
+			}
+			err = renderEvents(out, doc, r, ticks, inline, quote)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintln(out, ticks)
+			if err != nil {
+				return err
+			}
+		}
+		line++
+		c, ok := doc.mdText[line]
+		if !ok {
+			if wasComment {
+				_, err := fmt.Fprintln(out, ticks)
+				if err != nil {
+					return err
+				}
+			}
+			wasComment = false
+			_, err := fmt.Fprintln(out, sc.Text())
+			if err != nil {
+				return err
+			}
+		} else {
+			text := doc.prose(c)
+			if !wasComment {
+				_, err := fmt.Fprint(out, ticks)
+				if err != nil {
+					return err
+				}
+			} else {
+				text = strings.TrimPrefix(text, "\n")
+			}
+			_, err := fmt.Fprintf(out, "%s%s\n", text, ticks)
+			if err != nil {
+				return err
+			}
+			n := doc.fset.Position(c.End()).Line - line
+			err = skip(n, sc)
+			if err != nil {
+				return err
+			}
+			line += n
+			wasComment = false
+		}
+	}
+	if sc.Err() != nil {
+		return sc.Err()
+	}
+	if !wasComment {
+		_, err := fmt.Fprintln(out, ticks)
+		if err != nil {
+			return err
+		}
+	}
+	// Events of the last line, such as those of calls
+	// deferred by a function ending the source, follow
+	// the code.
+	if r, ok := doc.events[line]; ok {
+		err := renderEvents(out, doc, r, ticks, inline, quote)
+		if err != nil {
+			return err
+		}
+	}
+	if doc.halted != "" {
+		return renderEvents(out, doc, []enc.Event{doc.haltEvent()}, ticks, inline, quote)
+	}
+	return nil
+}
+
+// renderEvents renders the output events r from a single source
+// line of doc to out, fencing text output with ticks.
+func renderEvents(out io.Writer, doc *document, r []enc.Event, ticks string, inline, quote bool) error {
+	rep := strings.NewReplacer("\n", "\n> ")
+	for i, e := range r {
+		switch e.Stream {
+		case "stdin", "stdout", "stderr", "code":
+			if !strings.HasSuffix(e.Text, "\n") {
+				e.Text += "\n"
+			}
+			if quote {
+				_, err := fmt.Fprintf(out, "> %s%s\n> %s%s\n", ticks, fenceInfo(e.Stream, e.Lang), rep.Replace(e.Text), ticks)
+				if err != nil {
+					return err
+				}
+			} else {
+				_, err := fmt.Fprintf(out, "%s%s\n%s%s\n", ticks, fenceInfo(e.Stream, e.Lang), e.Text, ticks)
+				if err != nil {
+					return err
+				}
+			}
+		case "markdown":
+			_, err := fmt.Fprint(out, e.Text)
+			if err != nil {
+				return err
+			}
+		case "image":
+			if inline {
+				e.Image = strings.TrimPrefix(e.Image, "data:image/svg+xml,")
+				if e.Title == "" {
+					_, err := fmt.Fprintf(out, "![%s](%s)\n\n", e.Text, e.Image)
+					if err != nil {
+						return err
+					}
+				} else {
+					_, err := fmt.Fprintf(out, "![%s](%s %q)\n\n", e.Text, e.Image, e.Title)
+					if err != nil {
+						return err
+					}
+				}
+			} else {
+				name, err := doc.imageName(e, i, len(r))
+				if err != nil {
+					return err
+				}
+				if quote {
+					_, err = fmt.Fprint(out, "> ")
+					if err != nil {
+						return err
+					}
+				}
+				if e.Title == "" {
+					_, err = fmt.Fprintf(out, "![%s](%s)\n", e.Text, name)
+					if err != nil {
+						return err
+					}
+				} else {
+					_, err = fmt.Fprintf(out, "![%s](%s %q)\n", e.Text, name, e.Title)
+					if err != nil {
+						return err
+					}
+				}
+				if len(r) != 1 && i != len(r)-1 {
+					_, err = fmt.Fprintln(out)
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// fenceInfo returns the info string of the code fence enclosing
+// text output written to stream. Code is fenced with its language.
+func fenceInfo(stream, lang string) string {
+	if stream == "code" {
+		return lang
+	}
+	return stream
+}
+
+// haltEvent returns an event describing the limit that
+// stopped the document's program.
+func (d *document) haltEvent() enc.Event {
+	return enc.Event{Stream: "stderr", Text: d.halted}
+}
+
+// fence returns a code fence long enough to enclose any text
+// in the document.
+func (d *document) fence() string {
+	longTicks := longestTicks(string(d.src))
+	for _, grp := range d.events {
+		for _, e := range grp {
+			n := longestTicks(e.Text)
+			if n > longTicks {
+				longTicks = n
+			}
+		}
+	}
+	return strings.Repeat("`", max(longTicks+1, 3))
+}
+
+func longestTicks(s string) int {
+	var m, l int
+	for _, r := range s {
+		if r != '`' {
+			if l > m {
+				m = l
+			}
+			l = 0
+			continue
+		}
+		l++
+	}
+	return m
+}
+
+func skip(n int, sc *bufio.Scanner) error {
+	for n--; sc.Scan() && n > 0; n-- {
+	}
+	if n > 0 {
+		if sc.Err() != nil {
+			return sc.Err()
+		}
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
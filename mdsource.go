@@ -0,0 +1,243 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/kortschak/gd/internal/enc"
+)
+
+// block is a go fenced code block in a Markdown source.
+type block struct {
+	// start and end are the lines of the opening
+	// and closing code fences.
+	start, end int
+}
+
+// isMarkdown returns whether path is a Markdown source.
+func isMarkdown(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".md")
+}
+
+// parseMarkdown reads the Markdown source at path and parses the
+// go fenced code blocks it contains as a single program, rewriting
+// its imports to use the gd hook packages.
+//
+// The program is assembled by blanking all lines outside the go code
+// blocks so that line numbers in the program match the Markdown
+// source. If no block holds a package clause, the first line is
+// replaced with "package main".
+func parseMarkdown(path string) (*document, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(src), "\n")
+	code := make([]string, len(lines))
+	var (
+		blocks  []block
+		fence   string
+		isGo    bool
+		start   int
+		hasPkg  bool
+		inBlock bool
+	)
+	for i, l := range lines {
+		l = strings.TrimSuffix(l, "\r")
+		if !inBlock {
+			fence, isGo = openingFence(l)
+			if fence != "" {
+				inBlock = true
+				start = i + 1
+			}
+			continue
+		}
+		if isClosingFence(l, fence) {
+			if isGo {
+				blocks = append(blocks, block{start: start, end: i + 1})
+			}
+			inBlock = false
+			continue
+		}
+		if isGo {
+			code[i] = l
+			if strings.HasPrefix(l, "package ") {
+				hasPkg = true
+			}
+		}
+	}
+	if inBlock {
+		return nil, fmt.Errorf("%s:%d: unterminated code block", path, start)
+	}
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("%s: no go code blocks", path)
+	}
+	if !hasPkg {
+		code[0] = "package main"
+	}
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, strings.Join(code, "\n"), parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	rewriteImports(f)
+
+	d := findDirectives(path, f)
+
+	return &document{
+		path:    path,
+		src:     src,
+		fset:    fset,
+		file:    f,
+		blocks:  blocks,
+		expects: findExpectations(fset, f, nil, d.output),
+		inputs:  d.inputs,
+		stdin:   d.stdin,
+	}, nil
+}
+
+// openingFence returns the code fence opening a fenced code block
+// on the line l and whether the block holds Go code. If l does not
+// open a fenced code block, fence is empty.
+func openingFence(l string) (fence string, isGo bool) {
+	if !strings.HasPrefix(l, "```") && !strings.HasPrefix(l, "~~~") {
+		return "", false
+	}
+	n := len(l) - len(strings.TrimLeft(l, l[:1]))
+	fields := strings.Fields(l[n:])
+	return l[:n], len(fields) != 0 && fields[0] == "go"
+}
+
+// isClosingFence returns whether the line l closes a fenced code
+// block opened with fence.
+func isClosingFence(l, fence string) bool {
+	l = strings.TrimRight(l, " \t")
+	return strings.HasPrefix(l, fence) && strings.Trim(l, fence[:1]) == ""
+}
+
+// blockEvents returns the events attributed to lines within the
+// code block b, in line order.
+func (d *document) blockEvents(b block) [][]enc.Event {
+	var lines []int
+	for line := range d.events {
+		if b.start < line && line < b.end {
+			lines = append(lines, line)
+		}
+	}
+	sort.Ints(lines)
+	events := make([][]enc.Event, len(lines))
+	for i, line := range lines {
+		events[i] = d.events[line]
+	}
+	return events
+}
+
+// renderMarkdownSource renders the Markdown source document to out
+// with the output of each go code block following the block.
+func renderMarkdownSource(out io.Writer, doc *document, inline, quote bool) error {
+	ticks := doc.fence()
+	lines := strings.SplitAfter(string(doc.src), "\n")
+	var next int
+	for i, l := range lines {
+		_, err := io.WriteString(out, l)
+		if err != nil {
+			return err
+		}
+		if next == len(doc.blocks) || doc.blocks[next].end != i+1 {
+			continue
+		}
+		events := doc.blockEvents(doc.blocks[next])
+		next++
+		if len(events) == 0 {
+			continue
+		}
+		if !strings.HasSuffix(l, "\n") {
+			_, err = fmt.Fprintln(out)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = fmt.Fprintln(out)
+		if err != nil {
+			return err
+		}
+		for _, r := range events {
+			err = renderEvents(out, doc, r, ticks, inline, quote)
+			if err != nil {
+				return err
+			}
+		}
+		if i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+			_, err = fmt.Fprintln(out)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if doc.halted != "" {
+		if !strings.HasSuffix(string(doc.src), "\n") {
+			_, err := fmt.Fprintln(out)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := fmt.Fprintln(out)
+		if err != nil {
+			return err
+		}
+		return renderEvents(out, doc, []enc.Event{doc.haltEvent()}, ticks, inline, quote)
+	}
+	return nil
+}
+
+// markdownChunks returns the ordered chunks of a Markdown source
+// document. Images are referenced by file name unless inline is true.
+func (d *document) markdownChunks(inline bool) ([]chunk, error) {
+	lines := strings.SplitAfter(string(d.src), "\n")
+	var chunks []chunk
+	addText := func(kind string, start, end int) {
+		if start > end {
+			return
+		}
+		chunks = append(chunks, chunk{
+			Kind:  kind,
+			Start: start,
+			End:   end,
+			Text:  strings.Join(lines[start-1:end], ""),
+		})
+	}
+	prev := 1
+	for _, b := range d.blocks {
+		addText("prose", prev, b.start-1)
+		addText("code", b.start+1, b.end-1)
+		prev = b.end + 1
+
+		var events []output
+		for _, r := range d.blockEvents(b) {
+			o, err := d.outputs(r, inline)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, o...)
+		}
+		if len(events) != 0 {
+			chunks = append(chunks, chunk{Kind: "output", Start: b.end, End: b.end, Events: events})
+		}
+	}
+	last := len(lines)
+	if lines[last-1] == "" {
+		last--
+	}
+	addText("prose", prev, last)
+	return chunks, nil
+}
@@ -0,0 +1,178 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kortschak/gd/internal/enc"
+)
+
+// normalizers are the names of the built-in output normalizers.
+var normalizers = []string{"clock", "pointers", "tempdir"}
+
+// pointer matches hexadecimal addresses.
+var pointer = regexp.MustCompile(`\b0x[0-9a-f]{8,16}\b`)
+
+// fixedClock is the fixed date and time written in place of the date
+// and time of a log header, keyed by the length of the header's date
+// and time.
+var fixedClock = map[int]string{
+	len("2009/11/10 "):                 "2009/11/10 ",
+	len("23:00:00 "):                   "23:00:00 ",
+	len("23:00:00.000000 "):            "23:00:00.000000 ",
+	len("2009/11/10 23:00:00 "):        "2009/11/10 23:00:00 ",
+	len("2009/11/10 23:00:00.000000 "): "2009/11/10 23:00:00.000000 ",
+}
+
+// normalizer rewrites the text of output events so that output that
+// varies between runs is stable.
+type normalizer struct {
+	// clock specifies that the date and time in log
+	// headers are replaced with a fixed time.
+	clock bool
+
+	// pointers specifies that hexadecimal addresses
+	// are replaced with placeholders numbered in the
+	// order the addresses first appear.
+	pointers bool
+	addrs    map[string]string
+
+	// tempdir is a pattern matching paths in the system
+	// temporary directory. It is nil if temporary paths
+	// are not rewritten.
+	tempdir *regexp.Regexp
+
+	// rules are user-provided replacement rules.
+	rules []replacement
+}
+
+// replacement is a regular expression replacement rule.
+type replacement struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// parseRule parses a replacement rule written in the form
+// /pattern/replacement/, where any character not in the pattern
+// or replacement may be used in place of "/" and the final
+// delimiter is optional. The replacement may refer to submatches
+// as described by regexp.Regexp.Expand.
+func parseRule(s string) (replacement, error) {
+	delim, n := utf8.DecodeRuneInString(s)
+	if n == 0 {
+		return replacement{}, fmt.Errorf("invalid replacement rule: %q", s)
+	}
+	parts := strings.Split(s[n:], string(delim))
+	if len(parts) == 3 && parts[2] == "" {
+		parts = parts[:2]
+	}
+	if len(parts) != 2 {
+		return replacement{}, fmt.Errorf("invalid replacement rule: %q", s)
+	}
+	re, err := regexp.Compile(parts[0])
+	if err != nil {
+		return replacement{}, fmt.Errorf("invalid replacement rule: %q: %v", s, err)
+	}
+	return replacement{re: re, repl: parts[1]}, nil
+}
+
+// newNormalizer returns a normalizer using the comma-separated
+// built-in normalizers in names, and the replacement rules. The
+// name "all" selects all the built-in normalizers.
+func newNormalizer(names string, rules []string) (*normalizer, error) {
+	n := &normalizer{}
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "clock":
+			n.clock = true
+		case "pointers":
+			n.pointers = true
+		case "tempdir":
+			n.tempdir = tempdirPattern()
+		case "all":
+			n.clock = true
+			n.pointers = true
+			n.tempdir = tempdirPattern()
+		default:
+			return nil, fmt.Errorf("unknown normalizer: %q (valid: %s, all)", name, strings.Join(normalizers, ", "))
+		}
+	}
+	for _, r := range rules {
+		rule, err := parseRule(r)
+		if err != nil {
+			return nil, err
+		}
+		n.rules = append(n.rules, rule)
+	}
+	return n, nil
+}
+
+// tempdirPattern returns a pattern matching the system temporary
+// directory and the first element of a path within it.
+func tempdirPattern() *regexp.Regexp {
+	dir := filepath.Clean(os.TempDir())
+	return regexp.MustCompile(regexp.QuoteMeta(dir) + regexp.QuoteMeta(string(filepath.Separator)) + `([^\s/\\'"` + "`" + `]+)`)
+}
+
+// digits matches runs of decimal digits.
+var digits = regexp.MustCompile(`[0-9]+`)
+
+// normalize returns the events in trace with their text normalized.
+// The text of images and build information is not altered.
+func (n *normalizer) normalize(trace []enc.Event) []enc.Event {
+	if n == nil {
+		return trace
+	}
+	n.addrs = make(map[string]string)
+	normalized := make([]enc.Event, len(trace))
+	for i, e := range trace {
+		switch e.Stream {
+		case "image", enc.BuildInfoStream:
+		default:
+			e.Text = n.text(e)
+		}
+		normalized[i] = e
+	}
+	return normalized
+}
+
+// text returns the normalized text of e.
+func (n *normalizer) text(e enc.Event) string {
+	text := e.Text
+	if n.clock && len(e.Clock) == 2 {
+		start, end := e.Clock[0], e.Clock[1]
+		clock, ok := fixedClock[end-start]
+		if ok && 0 <= start && end <= len(text) {
+			text = text[:start] + clock + text[end:]
+		}
+	}
+	if n.pointers {
+		text = pointer.ReplaceAllStringFunc(text, func(addr string) string {
+			p, ok := n.addrs[addr]
+			if !ok {
+				p = fmt.Sprintf("0xPTR%d", len(n.addrs)+1)
+				n.addrs[addr] = p
+			}
+			return p
+		})
+	}
+	if n.tempdir != nil {
+		text = n.tempdir.ReplaceAllStringFunc(text, func(path string) string {
+			elem := n.tempdir.FindStringSubmatch(path)[1]
+			return "$TMPDIR/" + digits.ReplaceAllString(elem, "0")
+		})
+	}
+	for _, r := range n.rules {
+		text = r.re.ReplaceAllString(text, r.repl)
+	}
+	return text
+}
@@ -0,0 +1,137 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/kortschak/gd/internal/enc"
+)
+
+var clockTests = []struct {
+	name   string
+	flag   int
+	prefix string
+	msg    string
+	want   string
+}{
+	{
+		name: "no flags",
+		flag: 0,
+		msg:  "meeting at 12:30:45 today",
+		want: "meeting at 12:30:45 today\n",
+	},
+	{
+		name: "no flags date",
+		flag: 0,
+		msg:  "release 2020/01/02",
+		want: "release 2020/01/02\n",
+	},
+	{
+		name: "std flags",
+		flag: log.LstdFlags,
+		msg:  "meeting at 12:30:45 today",
+		want: "2009/11/10 23:00:00 meeting at 12:30:45 today\n",
+	},
+	{
+		name:   "std flags prefix",
+		flag:   log.LstdFlags,
+		prefix: "at 01:02:03 ",
+		msg:    "release 2020/01/02",
+		want:   "at 01:02:03 2009/11/10 23:00:00 release 2020/01/02\n",
+	},
+	{
+		name: "microseconds",
+		flag: log.Lmicroseconds,
+		msg:  "12:30:45.123456 elapsed",
+		want: "23:00:00.000000 12:30:45.123456 elapsed\n",
+	},
+	{
+		name: "date microseconds",
+		flag: log.Ldate | log.Lmicroseconds,
+		msg:  "done",
+		want: "2009/11/10 23:00:00.000000 done\n",
+	},
+	{
+		name:   "msgprefix",
+		flag:   log.LstdFlags | log.Lmsgprefix,
+		prefix: "app: ",
+		msg:    "started at 12:30:45",
+		want:   "2009/11/10 23:00:00 app: started at 12:30:45\n",
+	},
+	{
+		name:   "msgprefix date",
+		flag:   log.Ldate | log.Lmsgprefix,
+		prefix: "2020/01/02 ",
+		msg:    "done",
+		want:   "2009/11/10 2020/01/02 done\n",
+	},
+}
+
+func TestNormalizeClock(t *testing.T) {
+	n, err := newNormalizer("clock", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, test := range clockTests {
+		var buf bytes.Buffer
+		l := log.New(&buf, test.prefix, test.flag)
+		l.Print(test.msg)
+		e := enc.Event{
+			Stream: "stderr",
+			Func:   "log.Print",
+			Text:   buf.String(),
+			Clock:  enc.LogClock(test.flag, test.prefix),
+		}
+		got := n.text(e)
+		if got != test.want {
+			t.Errorf("unexpected normalized text for %s: got:%q want:%q", test.name, got, test.want)
+		}
+	}
+}
+
+var normalizerTests = []struct {
+	names string
+	rules []string
+	text  string
+	want  string
+}{
+	{
+		names: "",
+		rules: []string{"/[0-9]+ms/Nms/"},
+		text:  "took 15ms",
+		want:  "took Nms",
+	},
+	{
+		names: "pointers",
+		text:  "0xc000012345 0xc000067890 0xc000012345",
+		want:  "0xPTR1 0xPTR2 0xPTR1",
+	},
+	{
+		names: "clock",
+		text:  "0xc000012345 at 12:30:45",
+		want:  "0xc000012345 at 12:30:45",
+	},
+}
+
+func TestNormalizer(t *testing.T) {
+	for _, test := range normalizerTests {
+		n, err := newNormalizer(test.names, test.rules)
+		if err != nil {
+			t.Fatalf("unexpected error for %q %q: %v", test.names, test.rules, err)
+		}
+		got := n.normalize([]enc.Event{{Stream: "stdout", Text: test.text}})[0].Text
+		if got != test.want {
+			t.Errorf("unexpected normalized text for %q %q: got:%q want:%q", test.names, test.rules, got, test.want)
+		}
+	}
+
+	_, err := newNormalizer("clock,bogus", nil)
+	if err == nil {
+		t.Error("expected error for unknown normalizer")
+	}
+}
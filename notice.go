@@ -0,0 +1,125 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"strings"
+)
+
+// noticeFlags are the flags that are not recorded in the command
+// line of rendered documents since they do not affect the rendered
+// content, name machine-specific locations, or are recorded in another
+// form.
+var noticeFlags = map[string]bool{
+	"all":         true,
+	"cache":       true,
+	"check":       true,
+	"force":       true,
+	"into":        true,
+	"j":           true,
+	"param":       true,
+	"save-events": true,
+	"serve":       true,
+	"sweep":       true,
+	"txtar":       true,
+	"update":      true,
+	"watch":       true,
+}
+
+// pathFlags are the flags whose values are paths relative
+// to the working directory.
+var pathFlags = map[string]bool{
+	"events": true,
+	"stdin":  true,
+}
+
+// command returns the gd command line recorded in the document rendered
+// to the file target, or to stdout if target is empty. The command is
+// written as it would be run from the directory holding target, with
+// paths relative to that directory, so that the command does not depend
+// on the machine the document was rendered on. Build and environment
+// settings taken from configuration files are included as equivalent
+// flags, as are the document's parameters.
+func (o options) command(doc *document, target string) []string {
+	base := "."
+	if target != "" {
+		base = filepath.Dir(target)
+	}
+	cmd := append([]string{"gd"}, o.configArgs...)
+	flag.Visit(func(f *flag.Flag) {
+		if noticeFlags[f.Name] {
+			return
+		}
+		switch v := f.Value.(type) {
+		case *stringList:
+			for _, s := range *v {
+				cmd = append(cmd, "-"+f.Name+"="+s)
+			}
+			return
+		case interface{ IsBoolFlag() bool }:
+			if v.IsBoolFlag() && f.Value.String() == "true" {
+				cmd = append(cmd, "-"+f.Name)
+				return
+			}
+		}
+		val := f.Value.String()
+		switch {
+		case f.Name == "o" && target != "":
+			// The -o flag names a file in each source's
+			// directory when rendering with -all.
+			val = relPath(base, target)
+		case pathFlags[f.Name]:
+			val = relPath(base, val)
+		}
+		cmd = append(cmd, "-"+f.Name+"="+val)
+	})
+	cmd = append(cmd, paramArgs(o.params)...)
+	cmd = append(cmd, relPath(base, doc.path))
+	return append(cmd, doc.args...)
+}
+
+// relPath returns path relative to the directory base using forward
+// slashes. If path cannot be made relative to base, it is returned
+// unaltered.
+func relPath(base, path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	absBase, err := filepath.Abs(base)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	rel, err := filepath.Rel(absBase, abs)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// shellQuote returns s quoted for POSIX shells if it holds
+// characters that a shell would interpret.
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	for _, r := range s {
+		if !isShellSafe(r) {
+			return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+		}
+	}
+	return s
+}
+
+// isShellSafe returns whether r can appear unquoted in a shell word.
+func isShellSafe(r rune) bool {
+	switch {
+	case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("@%+=:,./_-", r)
+}
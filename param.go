@@ -0,0 +1,222 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/kortschak/gd/internal/enc"
+)
+
+// parseParams returns the document parameters held in list
+// in name=value form.
+func parseParams(list []string) (map[string]string, error) {
+	params := make(map[string]string)
+	for _, p := range list {
+		i := strings.Index(p, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid parameter: %q", p)
+		}
+		params[p[:i]] = p[i+1:]
+	}
+	return params, nil
+}
+
+// withParams returns o with the parameters in p added, replacing
+// parameters with the same name.
+func (o options) withParams(p map[string]string) options {
+	params := make(map[string]string, len(o.params)+len(p))
+	for k, v := range o.params {
+		params[k] = v
+	}
+	for k, v := range p {
+		params[k] = v
+	}
+	o.params = params
+	return o
+}
+
+// paramNames returns the sorted names of the parameters in p.
+func paramNames(p map[string]string) []string {
+	names := make([]string, 0, len(p))
+	for k := range p {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// paramsEnv returns the environment variable that passes the
+// parameters to the program.
+func (o options) paramsEnv() string {
+	// Marshaling a map of strings cannot fail.
+	p, _ := json.Marshal(o.params)
+	return enc.ParamsEnv + "=" + string(p)
+}
+
+// plainKey matches YAML keys that do not need quoting.
+var plainKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// renderFrontMatter writes a YAML front matter block holding the
+// parameters in p to out.
+func renderFrontMatter(out io.Writer, p map[string]string) error {
+	var buf strings.Builder
+	buf.WriteString("---\nparams:\n")
+	for _, k := range paramNames(p) {
+		// JSON strings are valid YAML flow scalars.
+		key := k
+		if !plainKey.MatchString(k) {
+			b, _ := json.Marshal(k)
+			key = string(b)
+		}
+		val, _ := json.Marshal(p[k])
+		fmt.Fprintf(&buf, "  %s: %s\n", key, val)
+	}
+	buf.WriteString("---\n")
+	_, err := io.WriteString(out, buf.String())
+	return err
+}
+
+// stripFrontMatter returns text without a leading front matter
+// block, and the block.
+func stripFrontMatter(text string) (rest, front string) {
+	if !strings.HasPrefix(text, "---\n") {
+		return text, ""
+	}
+	i := strings.Index(text[len("---\n"):], "\n---\n")
+	if i < 0 {
+		return text, ""
+	}
+	end := len("---\n") + i + len("\n---\n")
+	return text[end:], text[:end]
+}
+
+// axis is a parameter and the values it takes in a sweep.
+type axis struct {
+	name   string
+	values []string
+}
+
+// parseSweep returns the sweep axes held in list in
+// name=value,value,... form.
+func parseSweep(list []string) ([]axis, error) {
+	var axes []axis
+	seen := make(map[string]bool)
+	for _, s := range list {
+		i := strings.Index(s, "=")
+		if i <= 0 || i == len(s)-1 {
+			return nil, fmt.Errorf("invalid sweep: %q", s)
+		}
+		name := s[:i]
+		if seen[name] {
+			return nil, fmt.Errorf("parameter %s swept more than once", name)
+		}
+		seen[name] = true
+		axes = append(axes, axis{name: name, values: strings.Split(s[i+1:], ",")})
+	}
+	return axes, nil
+}
+
+// combinations returns every combination of the values of the axes.
+func combinations(axes []axis) []map[string]string {
+	combs := []map[string]string{{}}
+	for _, a := range axes {
+		var next []map[string]string
+		for _, c := range combs {
+			for _, v := range a.values {
+				m := make(map[string]string, len(c)+1)
+				for k, v := range c {
+					m[k] = v
+				}
+				m[a.name] = v
+				next = append(next, m)
+			}
+		}
+		combs = next
+	}
+	return combs
+}
+
+// variant returns the suffix distinguishing the files of the document
+// rendered with the parameter combination c of the sweep axes.
+func variant(axes []axis, c map[string]string) string {
+	clean := strings.NewReplacer("/", "_", `\`, "_", " ", "_")
+	var buf strings.Builder
+	for _, a := range axes {
+		buf.WriteString("-")
+		buf.WriteString(clean.Replace(c[a.name]))
+	}
+	return buf.String()
+}
+
+// variantTarget returns the output file name for the parameter
+// combination c of the sweep axes. Each {name} in target is replaced
+// by the value of the named parameter. The variant suffix for the
+// axes without a placeholder is inserted before the extension.
+func variantTarget(target string, axes []axis, c map[string]string) string {
+	var rest []axis
+	for _, a := range axes {
+		placeholder := "{" + a.name + "}"
+		if !strings.Contains(target, placeholder) {
+			rest = append(rest, a)
+			continue
+		}
+		target = strings.Replace(target, placeholder, c[a.name], -1)
+	}
+	ext := filepath.Ext(target)
+	return strings.TrimSuffix(target, ext) + variant(rest, c) + ext
+}
+
+// sweep renders the gd source at path once for each combination of
+// the values of the sweep axes, writing each document to a file named
+// by variantTarget.
+func sweep(path string, args []string, target string, axes []axis, opts options) error {
+	for _, c := range combinations(axes) {
+		opts := opts.withParams(c)
+		doc, err := load(path, args, opts)
+		if err != nil {
+			return err
+		}
+		doc.variant = variant(axes, c)
+		if doc.halted != "" {
+			log.Printf("%s %s: %s", path, formatCLargs(paramArgs(c)), doc.halted)
+		}
+		t := variantTarget(target, axes, c)
+		var buf bytes.Buffer
+		err = opts.render(&buf, doc, t)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(t, buf.Bytes(), 0666)
+		if err != nil {
+			return err
+		}
+		if !opts.inline {
+			err = writeImages(imageBase(t), doc)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// paramArgs returns the -param flags for the parameters in p.
+func paramArgs(p map[string]string) []string {
+	var args []string
+	for _, k := range paramNames(p) {
+		args = append(args, "-param="+k+"="+p[k])
+	}
+	return args
+}
@@ -0,0 +1,18 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !aix,!darwin,!dragonfly,!freebsd,!illumos,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+import "os/exec"
+
+// setProcessGroup is a no-op on platforms without process groups.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the started cmd. Processes started by cmd
+// are not killed on platforms without process groups.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,23 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build aix darwin dragonfly freebsd illumos linux netbsd openbsd solaris
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup arranges for cmd to be started in its own process
+// group so that it can be killed along with any processes it starts.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills the process group of the started cmd.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,100 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/kortschak/gd/internal/enc"
+)
+
+// provenance describes how a document's output was produced.
+type provenance struct {
+	// Go is the Go version the program was built
+	// with and Platform is its GOOS/GOARCH. They
+	// are empty if the program did not report its
+	// build information.
+	Go       string `json:"go,omitempty"`
+	Platform string `json:"platform,omitempty"`
+
+	// Source is the name of the source file and
+	// SHA256 is the hex encoded SHA-256 hash of
+	// its contents.
+	Source string `json:"source"`
+	SHA256 string `json:"sha256"`
+
+	// Args are the command line arguments passed
+	// to the program.
+	Args []string `json:"args"`
+
+	// Modules are the program's main module and
+	// its dependencies.
+	Modules []enc.Module `json:"modules,omitempty"`
+}
+
+// provenance returns the provenance of the document's output.
+func (d *document) provenance() *provenance {
+	sum := sha256.Sum256(d.src)
+	p := &provenance{
+		Source: filepath.Base(d.path),
+		SHA256: hex.EncodeToString(sum[:]),
+		Args:   d.args,
+	}
+	if p.Args == nil {
+		p.Args = []string{}
+	}
+	if d.build != nil {
+		p.Go = d.build.GoVersion
+		p.Platform = d.build.GOOS + "/" + d.build.GOARCH
+		if d.build.Main.Path != "" {
+			p.Modules = append(p.Modules, d.build.Main)
+		}
+		p.Modules = append(p.Modules, d.build.Deps...)
+	}
+	return p
+}
+
+// renderProvenance renders p to out as a Markdown block.
+func renderProvenance(out io.Writer, p *provenance) error {
+	var buf strings.Builder
+	fmt.Fprint(&buf, "\n---\n\n**Provenance**\n\n")
+	if p.Go == "" {
+		fmt.Fprint(&buf, "- Go: unknown\n")
+	} else {
+		fmt.Fprintf(&buf, "- Go: %s %s\n", p.Go, p.Platform)
+	}
+	fmt.Fprintf(&buf, "- Source: %s (sha256 %s)\n", codeSpan(p.Source), p.SHA256)
+	if len(p.Args) == 0 {
+		fmt.Fprint(&buf, "- Arguments: none\n")
+	} else {
+		fmt.Fprintf(&buf, "- Arguments: %s\n", codeSpan(formatCLargs(p.Args)))
+	}
+	if len(p.Modules) != 0 {
+		fmt.Fprint(&buf, "\n| Module | Version |\n| --- | --- |\n")
+		for _, m := range p.Modules {
+			version := m.Version
+			if m.Replace != nil {
+				version += " => " + strings.TrimSpace(m.Replace.Path+" "+m.Replace.Version)
+			}
+			fmt.Fprintf(&buf, "| %s | %s |\n", m.Path, version)
+		}
+	}
+	_, err := io.WriteString(out, buf.String())
+	return err
+}
+
+// codeSpan returns s as a Markdown code span.
+func codeSpan(s string) string {
+	ticks := strings.Repeat("`", longestTicks(s)+1)
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		return ticks + " " + s + " " + ticks
+	}
+	return ticks + s + ticks
+}
@@ -0,0 +1,11 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package main
+
+// limitMemory is a no-op on platforms other than linux. Memory use is
+// limited only by the program's GOMEMLIMIT soft limit.
+func limitMemory(pid int, n int64) error { return nil }
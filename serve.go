@@ -0,0 +1,195 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// server is a live-preview server for a rendered gd document.
+type server struct {
+	path string
+	args []string
+	opts options
+
+	mu      sync.Mutex
+	page    []byte
+	images  map[string][]byte
+	clients map[chan struct{}]bool
+}
+
+// serve renders the gd source at path as HTML and serves it on addr,
+// re-rendering and notifying browsers to reload when the source, its
+// declared input files or the hook packages change.
+func serve(addr, path string, args []string, opts options, interval time.Duration) error {
+	opts.format = "markdown"
+	opts.notice = false
+	opts.fragment = true
+	s := &server{
+		path:    path,
+		args:    args,
+		opts:    opts,
+		clients: make(map[chan struct{}]bool),
+	}
+
+	hookFiles, err := hookSources()
+	if err != nil {
+		log.Printf("not watching hook packages: %v", err)
+	}
+	files := append([]string{path}, hookFiles...)
+	render := func() {
+		doc := s.render()
+		if doc != nil {
+			files = append(watchedFiles(doc), hookFiles...)
+		}
+		s.notify()
+	}
+	render()
+	go poll(interval, func() []string { return files }, render)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.serveHTTP)
+	mux.HandleFunc("/_gd/events", s.serveEvents)
+	log.Printf("serving %s on %s", path, addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// render renders the server's document, holding the result for
+// serving. It returns the rendered document, or nil if rendering
+// failed.
+func (s *server) render() *document {
+	var buf bytes.Buffer
+	doc, err := load(s.path, s.args, s.opts)
+	var images map[string][]byte
+	if err == nil {
+		err = s.opts.render(&buf, doc, "")
+	}
+	if err == nil && !s.opts.inline {
+		images, err = doc.images()
+	}
+
+	var page bytes.Buffer
+	fmt.Fprintf(&page, pageHeader, html.EscapeString(filepath.Base(s.path)))
+	if err != nil {
+		log.Print(err)
+		fmt.Fprintf(&page, "<pre class=\"error\">%s</pre>\n", html.EscapeString(err.Error()))
+		doc = nil
+	} else {
+		page.WriteString(markdownToHTML(buf.String()))
+	}
+	page.WriteString(pageFooter)
+
+	s.mu.Lock()
+	s.page = page.Bytes()
+	if images != nil {
+		s.images = images
+	}
+	s.mu.Unlock()
+	return doc
+}
+
+// notify tells all connected browsers to reload.
+func (s *server) notify() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// serveHTTP serves the rendered page and its images.
+func (s *server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.URL.Path == "/" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(s.page)
+		return
+	}
+	img, ok := s.images[strings.TrimPrefix(r.URL.Path, "/")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch path.Ext(r.URL.Path) {
+	case ".svg":
+		w.Header().Set("Content-Type", "image/svg+xml")
+	case ".png":
+		w.Header().Set("Content-Type", "image/png")
+	case ".jpeg":
+		w.Header().Set("Content-Type", "image/jpeg")
+	}
+	w.Write(img)
+}
+
+// serveEvents serves a server-sent event stream that sends a reload
+// event each time the document is rendered.
+func (s *server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	c := make(chan struct{}, 1)
+	s.mu.Lock()
+	s.clients[c] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	f.Flush()
+	for {
+		select {
+		case <-c:
+			fmt.Fprint(w, "data: reload\n\n")
+			f.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+const (
+	pageHeader = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { max-width: 60em; margin: auto; padding: 1em; font-family: sans-serif; line-height: 1.5; }
+pre { background: #f6f8fa; padding: 0.5em; overflow: auto; }
+pre.stderr, pre.error { color: #b31d28; }
+blockquote { margin: 0 0 0 1em; padding-left: 1em; border-left: 0.25em solid #dfe2e5; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #dfe2e5; padding: 0.25em 0.5em; vertical-align: top; }
+img { max-width: 100%%; }
+</style>
+<script>
+new EventSource("/_gd/events").onmessage = function() { location.reload(); };
+</script>
+</head>
+<body>
+`
+	pageFooter = `</body>
+</html>
+`
+)
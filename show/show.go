@@ -7,13 +7,44 @@ package show
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"image"
 	"image/jpeg"
 	"image/png"
+	"os"
+	"strings"
+	"sync"
 
 	"github.com/kortschak/gd/internal/enc"
 )
 
+var (
+	paramsOnce sync.Once
+	params     map[string]string
+)
+
+// Param returns the value of the document parameter with the given
+// name, or def if the parameter is not set. Parameters are set with
+// the gd -param and -sweep flags.
+func Param(name, def string) string {
+	paramsOnce.Do(func() {
+		p := os.Getenv(enc.ParamsEnv)
+		if p == "" {
+			return
+		}
+		err := json.Unmarshal([]byte(p), &params)
+		if err != nil {
+			panic(fmt.Sprintf("show: invalid %s: %v", enc.ParamsEnv, err))
+		}
+	})
+	v, ok := params[name]
+	if !ok {
+		return def
+	}
+	return v
+}
+
 // Markdown renders the Markdown text into the event stream.
 func Markdown(text string) error {
 	e := enc.Event{
@@ -23,6 +54,19 @@ func Markdown(text string) error {
 	return enc.Encode(e, 1)
 }
 
+// Code renders the text into the event stream as a code block with
+// the given language, such as json, sql, yaml or diff. The language
+// is used as the info string of the block's code fence so that the
+// text is rendered with syntax highlighting.
+func Code(lang, text string) error {
+	e := enc.Event{
+		Stream: "code",
+		Text:   text,
+		Lang:   strings.TrimSpace(lang),
+	}
+	return enc.Encode(e, 1)
+}
+
 // JPEG renders the given image, title and alt text into the event stream as a JPEG.
 func JPEG(img image.Image, o *jpeg.Options, text, title string) error {
 	var buf bytes.Buffer
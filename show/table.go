@@ -0,0 +1,227 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package show
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kortschak/gd/internal/enc"
+)
+
+// TableOptions are the options for rendering a table.
+type TableOptions struct {
+	// Header holds the column names of a [][]string
+	// table. If Header is nil, the first row of the
+	// table is used.
+	Header []string
+
+	// MaxRows is the maximum number of rows rendered.
+	// Rows after the first MaxRows are elided and the
+	// number of rows not shown is noted. All rows are
+	// rendered if MaxRows is zero.
+	MaxRows int
+}
+
+// Table renders v into the event stream as a Markdown table. The value
+// v must be one of the following.
+//
+//   - A slice or array of structs or pointers to structs. Each element
+//     is a row and each exported field is a column named by the field
+//     name, or by the name given in a `show:"name"` field tag. Fields
+//     tagged `show:"-"` are not rendered.
+//   - A [][]string. The column names are taken from o.Header or, if it
+//     is nil, from the first row.
+//   - A map with string keys. Each entry is a row, sorted by key. The
+//     first column holds the key and the remaining columns hold the
+//     fields of struct values, or the value itself.
+//
+// Pipes and newlines in cells are escaped, and columns holding only
+// numbers are right aligned. A nil o is equivalent to a zero
+// TableOptions.
+func Table(v interface{}, o *TableOptions) error {
+	if o == nil {
+		o = &TableOptions{}
+	}
+	header, rows, err := tableOf(v, o)
+	if err != nil {
+		return err
+	}
+	if len(header) == 0 {
+		return fmt.Errorf("show: table has no columns")
+	}
+	e := enc.Event{
+		Stream: "markdown",
+		Text:   markdownTable(header, rows, o.MaxRows),
+	}
+	return enc.Encode(e, 1)
+}
+
+// tableOf returns the column names and cells of the table held in v.
+func tableOf(v interface{}, o *TableOptions) (header []string, rows [][]string, err error) {
+	if t, ok := v.([][]string); ok {
+		header = o.Header
+		if header == nil {
+			if len(t) == 0 {
+				return nil, nil, fmt.Errorf("show: table has no header")
+			}
+			header, t = t[0], t[1:]
+		}
+		return header, t, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		typ := elemStruct(rv.Type().Elem())
+		if typ == nil {
+			break
+		}
+		fields, header := columns(typ)
+		for i := 0; i < rv.Len(); i++ {
+			rows = append(rows, fieldCells(rv.Index(i), fields))
+		}
+		return header, rows, nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			break
+		}
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		typ := elemStruct(rv.Type().Elem())
+		if typ == nil {
+			header = []string{"Key", "Value"}
+			for _, k := range keys {
+				rows = append(rows, []string{k.String(), fmt.Sprint(rv.MapIndex(k))})
+			}
+			return header, rows, nil
+		}
+		fields, names := columns(typ)
+		header = append([]string{"Key"}, names...)
+		for _, k := range keys {
+			rows = append(rows, append([]string{k.String()}, fieldCells(rv.MapIndex(k), fields)...))
+		}
+		return header, rows, nil
+	}
+	return nil, nil, fmt.Errorf("show: cannot render %T as a table", v)
+}
+
+// elemStruct returns the struct type of elements of type typ if
+// typ is a struct or a pointer to a struct, and nil otherwise.
+func elemStruct(typ reflect.Type) reflect.Type {
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil
+	}
+	return typ
+}
+
+// columns returns the indexes and column names of the rendered fields
+// of the struct type typ.
+func columns(typ reflect.Type) (fields []int, names []string) {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" {
+			// Unexported.
+			continue
+		}
+		name := f.Tag.Get("show")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = f.Name
+		}
+		fields = append(fields, i)
+		names = append(names, name)
+	}
+	return fields, names
+}
+
+// fieldCells returns the cells of the struct or pointer to struct v
+// for the given field indexes. The cells of a nil pointer are empty.
+func fieldCells(v reflect.Value, fields []int) []string {
+	cells := make([]string, len(fields))
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return cells
+		}
+		v = v.Elem()
+	}
+	for i, f := range fields {
+		cells[i] = fmt.Sprint(v.Field(f))
+	}
+	return cells
+}
+
+// markdownTable returns the Markdown table with the given column names
+// and cells, rendering at most maxRows rows if maxRows is positive.
+// Missing cells are rendered empty and extra cells are dropped.
+func markdownTable(header []string, rows [][]string, maxRows int) string {
+	total := len(rows)
+	if maxRows > 0 && len(rows) > maxRows {
+		rows = rows[:maxRows]
+	}
+
+	var buf strings.Builder
+	writeRow(&buf, header)
+	for i := range header {
+		if isNumeric(rows, i) {
+			buf.WriteString("|--:")
+		} else {
+			buf.WriteString("|---")
+		}
+	}
+	buf.WriteString("|\n")
+	for _, r := range rows {
+		if len(r) < len(header) {
+			r = append(r[:len(r):len(r)], make([]string, len(header)-len(r))...)
+		}
+		writeRow(&buf, r[:len(header)])
+	}
+	if len(rows) < total {
+		fmt.Fprintf(&buf, "\n_%d of %d rows shown._\n", len(rows), total)
+	}
+	return buf.String()
+}
+
+// writeRow writes a Markdown table row holding the cells to buf.
+func writeRow(buf *strings.Builder, cells []string) {
+	for _, c := range cells {
+		buf.WriteByte('|')
+		buf.WriteString(escapeCell(c))
+	}
+	buf.WriteString("|\n")
+}
+
+// cellEscaper escapes text that would break a Markdown table row.
+var cellEscaper = strings.NewReplacer("|", `\|`, "\r\n", "<br>", "\n", "<br>")
+
+// escapeCell returns the cell text c escaped for a Markdown table.
+func escapeCell(c string) string {
+	return cellEscaper.Replace(c)
+}
+
+// isNumeric returns whether the non-empty cells of column i of rows
+// are all numbers and there is at least one non-empty cell.
+func isNumeric(rows [][]string, i int) bool {
+	var n int
+	for _, r := range rows {
+		if i >= len(r) || r[i] == "" {
+			continue
+		}
+		_, err := strconv.ParseFloat(strings.TrimSpace(r[i]), 64)
+		if err != nil {
+			return false
+		}
+		n++
+	}
+	return n != 0
+}
@@ -0,0 +1,444 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package show
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kortschak/gd/internal/enc"
+)
+
+// ValueOptions are the options for rendering a value.
+type ValueOptions struct {
+	// Format is the format of the rendered value, one
+	// of "go", "json" or "yaml". The zero value is "go".
+	//
+	// The go format is a Go composite literal-like dump
+	// showing type names, with struct field tags given
+	// as comments. The json and yaml formats render the
+	// value as it is marshaled by encoding/json.
+	Format string
+
+	// MaxDepth is the maximum depth of nested values
+	// rendered in the go format. Values nested more
+	// deeply are elided. The zero value is 10.
+	MaxDepth int
+}
+
+// Value renders v into the event stream as a fenced Go code block
+// holding a pretty-printed dump of the value, captioned with label if
+// it is not empty. Pointer cycles are marked rather than followed.
+func Value(v interface{}, label string) error {
+	text, err := formatValue(v, label, nil)
+	if err != nil {
+		return err
+	}
+	return enc.Encode(enc.Event{Stream: "markdown", Text: text}, 1)
+}
+
+// ValueWith renders v into the event stream as Value does, using the
+// format and depth limit given in o. A nil o is equivalent to a zero
+// ValueOptions.
+func ValueWith(v interface{}, label string, o *ValueOptions) error {
+	text, err := formatValue(v, label, o)
+	if err != nil {
+		return err
+	}
+	return enc.Encode(enc.Event{Stream: "markdown", Text: text}, 1)
+}
+
+// formatValue returns the Markdown rendering of v.
+func formatValue(v interface{}, label string, o *ValueOptions) (string, error) {
+	if o == nil {
+		o = &ValueOptions{}
+	}
+	var (
+		buf  bytes.Buffer
+		lang string
+	)
+	switch o.Format {
+	case "", "go":
+		lang = "go"
+		depth := o.MaxDepth
+		if depth <= 0 {
+			depth = 10
+		}
+		d := dumper{w: &buf, maxDepth: depth, seen: make(map[uintptr]bool)}
+		d.dump(reflect.ValueOf(v), 0, false)
+		buf.WriteByte('\n')
+	case "json":
+		lang = "json"
+		b, err := json.MarshalIndent(v, "", "\t")
+		if err != nil {
+			return "", err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	case "yaml":
+		lang = "yaml"
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		n, err := decodeNode(json.NewDecoder(bytes.NewReader(b)))
+		if err != nil {
+			return "", err
+		}
+		writeYAML(&buf, n, "")
+	default:
+		return "", fmt.Errorf("show: unknown value format: %q", o.Format)
+	}
+
+	var md strings.Builder
+	if label != "" {
+		fmt.Fprintf(&md, "_%s_\n\n", label)
+	}
+	ticks := fence(buf.String())
+	fmt.Fprintf(&md, "%s%s\n%s%s\n", ticks, lang, buf.Bytes(), ticks)
+	return md.String(), nil
+}
+
+// fence returns a code fence long enough to enclose text.
+func fence(text string) string {
+	var n, longest int
+	for _, r := range text {
+		if r == '`' {
+			n++
+			if n > longest {
+				longest = n
+			}
+		} else {
+			n = 0
+		}
+	}
+	if longest < 3 {
+		return "```"
+	}
+	return strings.Repeat("`", longest+1)
+}
+
+// dumper writes Go composite literal-like dumps of values.
+type dumper struct {
+	w        io.Writer
+	maxDepth int
+	// seen holds the addresses of the pointers
+	// being dumped, for detecting cycles.
+	seen map[uintptr]bool
+}
+
+// dump writes the value v at the given nesting depth. If elide is true
+// the type of a composite value is omitted since it is implied by the
+// enclosing value.
+func (d *dumper) dump(v reflect.Value, depth int, elide bool) {
+	if !v.IsValid() {
+		io.WriteString(d.w, "nil")
+		return
+	}
+	typ := v.Type()
+	if s, ok := stringer(v); ok {
+		fmt.Fprintf(d.w, "%s(%s)", typ, strconv.Quote(s))
+		return
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		fmt.Fprint(d.w, v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		fmt.Fprint(d.w, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		fmt.Fprint(d.w, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		io.WriteString(d.w, strconv.FormatFloat(v.Float(), 'g', -1, typ.Bits()))
+	case reflect.Complex64, reflect.Complex128:
+		fmt.Fprint(d.w, v.Complex())
+	case reflect.String:
+		io.WriteString(d.w, strconv.Quote(v.String()))
+	case reflect.Interface:
+		if v.IsNil() {
+			io.WriteString(d.w, "nil")
+			return
+		}
+		d.dump(v.Elem(), depth, false)
+	case reflect.Ptr:
+		if v.IsNil() {
+			io.WriteString(d.w, "nil")
+			return
+		}
+		if d.seen[v.Pointer()] {
+			fmt.Fprintf(d.w, "&%s{/* cycle */}", typ.Elem())
+			return
+		}
+		d.seen[v.Pointer()] = true
+		defer delete(d.seen, v.Pointer())
+		io.WriteString(d.w, "&")
+		d.dump(v.Elem(), depth, false)
+	case reflect.Struct:
+		d.open(typ, elide)
+		if depth >= d.maxDepth {
+			io.WriteString(d.w, "/* ... */}")
+			return
+		}
+		if typ.NumField() == 0 {
+			io.WriteString(d.w, "}")
+			return
+		}
+		io.WriteString(d.w, "\n")
+		indent := strings.Repeat("\t", depth+1)
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			fmt.Fprintf(d.w, "%s%s: ", indent, f.Name)
+			d.dump(v.Field(i), depth+1, false)
+			io.WriteString(d.w, ",")
+			if f.Tag != "" {
+				fmt.Fprintf(d.w, " // `%s`", f.Tag)
+			}
+			io.WriteString(d.w, "\n")
+		}
+		fmt.Fprintf(d.w, "%s}", strings.Repeat("\t", depth))
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			io.WriteString(d.w, "nil")
+			return
+		}
+		d.open(typ, elide)
+		if v.Len() == 0 {
+			io.WriteString(d.w, "}")
+			return
+		}
+		if depth >= d.maxDepth {
+			io.WriteString(d.w, "/* ... */}")
+			return
+		}
+		if isScalar(typ.Elem()) {
+			for i := 0; i < v.Len(); i++ {
+				if i != 0 {
+					io.WriteString(d.w, ", ")
+				}
+				d.dump(v.Index(i), depth+1, true)
+			}
+			io.WriteString(d.w, "}")
+			return
+		}
+		io.WriteString(d.w, "\n")
+		indent := strings.Repeat("\t", depth+1)
+		for i := 0; i < v.Len(); i++ {
+			io.WriteString(d.w, indent)
+			d.dump(v.Index(i), depth+1, true)
+			io.WriteString(d.w, ",\n")
+		}
+		fmt.Fprintf(d.w, "%s}", strings.Repeat("\t", depth))
+	case reflect.Map:
+		if v.IsNil() {
+			io.WriteString(d.w, "nil")
+			return
+		}
+		d.open(typ, elide)
+		if v.Len() == 0 {
+			io.WriteString(d.w, "}")
+			return
+		}
+		if depth >= d.maxDepth {
+			io.WriteString(d.w, "/* ... */}")
+			return
+		}
+		if d.seen[v.Pointer()] {
+			io.WriteString(d.w, "/* cycle */}")
+			return
+		}
+		d.seen[v.Pointer()] = true
+		defer delete(d.seen, v.Pointer())
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+		})
+		io.WriteString(d.w, "\n")
+		indent := strings.Repeat("\t", depth+1)
+		for _, k := range keys {
+			io.WriteString(d.w, indent)
+			d.dump(k, depth+1, true)
+			io.WriteString(d.w, ": ")
+			d.dump(v.MapIndex(k), depth+1, true)
+			io.WriteString(d.w, ",\n")
+		}
+		fmt.Fprintf(d.w, "%s}", strings.Repeat("\t", depth))
+	default:
+		// Channels, functions and unsafe pointers.
+		if v.IsNil() {
+			io.WriteString(d.w, "nil")
+			return
+		}
+		fmt.Fprintf(d.w, "(%s)(/* non-nil */)", typ)
+	}
+}
+
+// open writes the opening of a composite literal of type typ,
+// omitting the type if elide is true.
+func (d *dumper) open(typ reflect.Type, elide bool) {
+	if !elide {
+		io.WriteString(d.w, typ.String())
+	}
+	io.WriteString(d.w, "{")
+}
+
+// stringer returns the result of the String or Error method of the
+// struct or non-nil pointer value v, and whether it has one. Only
+// values that can be used without accessing unexported fields are
+// considered.
+func stringer(v reflect.Value) (string, bool) {
+	switch {
+	case !v.CanInterface():
+		return "", false
+	case v.Kind() == reflect.Ptr:
+		if v.IsNil() {
+			return "", false
+		}
+	case v.Kind() != reflect.Struct:
+		return "", false
+	}
+	switch s := v.Interface().(type) {
+	case error:
+		return s.Error(), true
+	case fmt.Stringer:
+		return s.String(), true
+	}
+	return "", false
+}
+
+// isScalar returns whether values of type typ are dumped on
+// a single line.
+func isScalar(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128,
+		reflect.String:
+		return true
+	}
+	return false
+}
+
+// node is a decoded JSON value with object keys held in order.
+type node struct {
+	// scalar holds the JSON text of a string,
+	// number, boolean or null.
+	scalar string
+	// isObject and isArray specify that the node
+	// is an object or array holding the elements,
+	// named by keys for an object.
+	isObject, isArray bool
+	keys              []string
+	elems             []*node
+}
+
+// decodeNode returns the next JSON value from dec.
+func decodeNode(dec *json.Decoder) (*node, error) {
+	dec.UseNumber()
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		n := &node{isObject: tok == '{', isArray: tok == '['}
+		for dec.More() {
+			if n.isObject {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				n.keys = append(n.keys, key.(string))
+			}
+			e, err := decodeNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			n.elems = append(n.elems, e)
+		}
+		// Consume the closing delimiter.
+		_, err = dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		return n, nil
+	case string:
+		return &node{scalar: yamlString(tok)}, nil
+	case nil:
+		return &node{scalar: "null"}, nil
+	default:
+		return &node{scalar: fmt.Sprint(tok)}, nil
+	}
+}
+
+// plainYAML matches strings that need no quoting in YAML.
+var plainYAML = regexp.MustCompile(`^[A-Za-z_/][A-Za-z0-9_./ -]*$`)
+
+// yamlString returns s as a YAML scalar, quoting it if needed.
+func yamlString(s string) string {
+	switch strings.ToLower(s) {
+	case "true", "false", "yes", "no", "on", "off", "null", "~":
+		return strconv.Quote(s)
+	}
+	if plainYAML.MatchString(s) && !strings.HasSuffix(s, " ") {
+		return s
+	}
+	// JSON strings are valid YAML flow scalars.
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// writeYAML writes n to w as a YAML block with the given indent.
+func writeYAML(w io.Writer, n *node, indent string) {
+	switch {
+	case n.isObject && len(n.elems) == 0:
+		io.WriteString(w, "{}\n")
+	case n.isArray && len(n.elems) == 0:
+		io.WriteString(w, "[]\n")
+	case n.isObject:
+		for i, e := range n.elems {
+			if i != 0 {
+				io.WriteString(w, indent)
+			}
+			fmt.Fprintf(w, "%s:", yamlString(n.keys[i]))
+			writeYAMLElem(w, e, indent+"  ")
+		}
+	case n.isArray:
+		for i, e := range n.elems {
+			if i != 0 {
+				io.WriteString(w, indent)
+			}
+			io.WriteString(w, "-")
+			if (e.isObject || e.isArray) && len(e.elems) != 0 {
+				// Nested blocks start on the same line
+				// as the sequence entry.
+				io.WriteString(w, " ")
+				writeYAML(w, e, indent+"  ")
+				continue
+			}
+			writeYAMLElem(w, e, indent+"  ")
+		}
+	default:
+		fmt.Fprintf(w, "%s\n", n.scalar)
+	}
+}
+
+// writeYAMLElem writes the element n of a mapping or sequence
+// following its key or entry marker.
+func writeYAMLElem(w io.Writer, n *node, indent string) {
+	if (n.isObject || n.isArray) && len(n.elems) != 0 {
+		fmt.Fprintf(w, "\n%s", indent)
+		writeYAML(w, n, indent)
+		return
+	}
+	io.WriteString(w, " ")
+	writeYAML(w, n, indent)
+}
@@ -0,0 +1,90 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package show
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+
+	"github.com/kortschak/gd/internal/enc"
+)
+
+// BlockWriter is an io.WriteCloser that collects writes into a single
+// output block. The block is rendered into the event stream when the
+// writer is flushed or closed, attributed to the line of the Flush or
+// Close call. A deferred Flush or Close is attributed to the line where
+// it runs, the closing brace or return statement of the function that
+// deferred it.
+type BlockWriter struct {
+	mu     sync.Mutex
+	kind   string
+	buf    bytes.Buffer
+	closed bool
+}
+
+// Writer returns a BlockWriter that renders its output as the given kind
+// of block. The kind is "stdout", "stderr" or "markdown" for text or
+// Markdown output blocks. Any other kind is the language of a code
+// block, as rendered by Code.
+func Writer(kind string) *BlockWriter {
+	return &BlockWriter{kind: kind}
+}
+
+// Write appends p to the block.
+func (w *BlockWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, errors.New("show: write to closed writer")
+	}
+	return w.buf.Write(p)
+}
+
+// Flush renders the text written since the last flush into the
+// event stream. Nothing is rendered if no text has been written.
+func (w *BlockWriter) Flush() error {
+	w.mu.Lock()
+	e, ok := w.take()
+	w.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return enc.Encode(e, 1)
+}
+
+// Close renders the text written since the last flush into the event
+// stream and closes the writer.
+func (w *BlockWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return errors.New("show: writer already closed")
+	}
+	w.closed = true
+	e, ok := w.take()
+	w.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return enc.Encode(e, 1)
+}
+
+// take returns an event holding the buffered text, and whether there
+// is any, and resets the buffer. It must be called with w.mu held.
+func (w *BlockWriter) take() (enc.Event, bool) {
+	if w.buf.Len() == 0 {
+		return enc.Event{}, false
+	}
+	e := enc.Event{Stream: w.kind, Text: w.buf.String()}
+	switch w.kind {
+	case "stdout", "stderr", "markdown":
+	default:
+		e.Stream = "code"
+		e.Lang = w.kind
+	}
+	w.buf.Reset()
+	return e, true
+}
@@ -0,0 +1,254 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kortschak/gd/internal/enc"
+)
+
+// archiveFile is a single file in a txtar archive.
+type archiveFile struct {
+	name string
+	data []byte
+}
+
+// writeTxtar writes a txtar archive to path holding the document's
+// source, its declared input and stdin files, the output captured when
+// it was run and, unless images are rendered inline, its image files.
+// Text output is stored in files named for the stream it was written
+// to. A go.mod file requiring the modules the program was built with
+// makes the archive runnable when extracted outside a module. The
+// archive records the gd command line, with the options used to render
+// the document, that reproduces the output from the archived files.
+// Input files must be within the source's directory.
+func writeTxtar(path string, doc *document, opts options) error {
+	name := filepath.Base(doc.path)
+	files := []archiveFile{{name: name, data: doc.src}}
+
+	dir := filepath.Dir(doc.path)
+	for _, in := range doc.inputs {
+		if !isLocal(in) {
+			return fmt.Errorf("cannot archive input file outside the source directory: %s", in)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, in))
+		if err != nil {
+			return err
+		}
+		files = append(files, archiveFile{name: filepath.ToSlash(in), data: data})
+	}
+	if doc.stdin != "" {
+		data, err := ioutil.ReadFile(doc.stdin)
+		if err != nil {
+			return err
+		}
+		files = append(files, archiveFile{name: "stdin", data: data})
+	}
+
+	streams := make(map[string]*bytes.Buffer)
+	for _, e := range doc.trace {
+		switch e.Stream {
+		case "stdout", "stderr", "markdown", "code":
+			buf, ok := streams[e.Stream]
+			if !ok {
+				buf = &bytes.Buffer{}
+				streams[e.Stream] = buf
+			}
+			buf.WriteString(e.Text)
+		}
+	}
+	for _, s := range []string{"stdout", "stderr", "markdown", "code"} {
+		buf, ok := streams[s]
+		if ok {
+			files = append(files, archiveFile{name: s, data: buf.Bytes()})
+		}
+	}
+
+	if !opts.inline {
+		images, err := doc.images()
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(images))
+		for name := range images {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			files = append(files, archiveFile{name: name, data: images[name]})
+		}
+	}
+
+	if doc.build != nil {
+		mod, sum := bundleModule(doc.build)
+		files = append(files, archiveFile{name: "go.mod", data: mod})
+		if len(sum) != 0 {
+			files = append(files, archiveFile{name: "go.sum", data: sum})
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Run with `%s` and compare the output with the stream and image files.\n", formatCLargs(opts.bundleCommand(doc)))
+	for _, f := range files {
+		fmt.Fprintf(&buf, "-- %s --\n", f.name)
+		buf.Write(f.data)
+		if len(f.data) != 0 && f.data[len(f.data)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0664)
+}
+
+// bundleFlags are the flags of the recorded command that are replaced
+// in the command recorded in a txtar archive. The output is written to
+// stdout, the program is run rather than replayed, standard input is
+// read from the archived stdin file, and the output filters and limits
+// are written with the settings from configuration files included.
+var bundleFlags = []string{"-o=", "-events=", "-stdin=", "-normalize=", "-replace=", "-timeout=", "-memlimit=", "-outlimit="}
+
+// isLocal returns whether the relative path p is within
+// the directory it is relative to.
+func isLocal(p string) bool {
+	p = filepath.Clean(filepath.FromSlash(p))
+	return !filepath.IsAbs(p) && p != ".." && !strings.HasPrefix(p, ".."+string(filepath.Separator))
+}
+
+// hasEnv returns whether env, in key=value form, sets key.
+func hasEnv(env []string, key string) bool {
+	for _, e := range env {
+		if strings.HasPrefix(e, key+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// bundleCommand returns the gd command line that reproduces the output
+// of the document from the files of a txtar archive extracted into the
+// working directory.
+func (o options) bundleCommand(doc *document) []string {
+	full := o.command(doc, "")
+	// The command ends with the source and the program arguments.
+	flags := full[:len(full)-len(doc.args)-1]
+
+	var cmd []string
+outer:
+	for _, a := range flags {
+		for _, f := range bundleFlags {
+			if strings.HasPrefix(a, f) {
+				continue outer
+			}
+		}
+		cmd = append(cmd, a)
+	}
+	if o.normalize != "" {
+		cmd = append(cmd, "-normalize="+o.normalize)
+	}
+	for _, r := range o.replace {
+		cmd = append(cmd, "-replace="+r)
+	}
+	if o.timeout != 0 {
+		cmd = append(cmd, fmt.Sprintf("-timeout=%v", o.timeout))
+	}
+	if o.memlimit != 0 {
+		cmd = append(cmd, fmt.Sprintf("-memlimit=%v", o.memlimit))
+	}
+	if o.outlimit != 0 {
+		cmd = append(cmd, fmt.Sprintf("-outlimit=%v", o.outlimit))
+	}
+	if doc.stdin != "" {
+		cmd = append(cmd, "-stdin=stdin")
+	}
+	if doc.build != nil && !hasEnv(o.env, "GOFLAGS") {
+		// The archive's go.sum does not hold the
+		// hashes of the required go.mod files.
+		cmd = append(cmd, "-env=GOFLAGS=-mod=mod")
+	}
+	cmd = append(cmd, filepath.Base(doc.path))
+	return append(cmd, doc.args...)
+}
+
+// bundleModule returns the go.mod and go.sum files of a module requiring
+// the modules of the program described by build. Modules built from a
+// development version have no version to require, and are noted in a
+// comment.
+func bundleModule(build *enc.BuildInfo) (mod, sum []byte) {
+	var m, ms bytes.Buffer
+	m.WriteString("module bundle\n")
+	if v := goRelease(build.GoVersion); v != "" {
+		fmt.Fprintf(&m, "\ngo %s\n", v)
+	}
+	var require, replace, devel []string
+	for _, d := range build.Deps {
+		if !isVersion(d.Version) {
+			devel = append(devel, d.Path)
+			continue
+		}
+		require = append(require, d.Path+" "+d.Version)
+		r := d
+		if d.Replace != nil {
+			if !isVersion(d.Replace.Version) {
+				devel = append(devel, d.Path)
+				continue
+			}
+			replace = append(replace, fmt.Sprintf("%s => %s %s", d.Path, d.Replace.Path, d.Replace.Version))
+			r = *d.Replace
+		}
+		if r.Sum != "" {
+			fmt.Fprintf(&ms, "%s %s %s\n", r.Path, r.Version, r.Sum)
+		}
+	}
+	for _, block := range []struct {
+		verb  string
+		lines []string
+	}{
+		{verb: "require", lines: require},
+		{verb: "replace", lines: replace},
+	} {
+		if len(block.lines) == 0 {
+			continue
+		}
+		fmt.Fprintf(&m, "\n%s (\n", block.verb)
+		for _, l := range block.lines {
+			fmt.Fprintf(&m, "\t%s\n", l)
+		}
+		m.WriteString(")\n")
+	}
+	if len(devel) != 0 {
+		m.WriteString("\n// These modules were built from a development version\n// and must be provided with a replace directive:\n")
+		for _, p := range devel {
+			fmt.Fprintf(&m, "//\t%s\n", p)
+		}
+	}
+	return m.Bytes(), ms.Bytes()
+}
+
+// goRelease returns the language version of the Go release named by v,
+// such as "1.16" for "go1.16.3", or the empty string if v is not a
+// release.
+func goRelease(v string) string {
+	if !strings.HasPrefix(v, "go1.") {
+		return ""
+	}
+	parts := strings.SplitN(strings.TrimPrefix(v, "go"), ".", 3)
+	for _, p := range parts[:2] {
+		if p == "" || strings.Trim(p, "0123456789") != "" {
+			return ""
+		}
+	}
+	return parts[0] + "." + parts[1]
+}
+
+// isVersion returns whether v is a module version rather than
+// a development build marker.
+func isVersion(v string) bool {
+	return strings.HasPrefix(v, "v")
+}
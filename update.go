@@ -0,0 +1,116 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// updateRegions replaces the content of regions in the Markdown file at
+// path marked by
+//
+//  <!-- gd:begin name [args...] -->
+//  <!-- gd:end -->
+//
+// with the rendering of the gd source, name, run with the given args.
+// The source name is relative to the directory holding the Markdown
+// file. If only is not empty, only regions with names in only are
+// updated. All other text in the file is left unaltered.
+func updateRegions(path string, only []string, opts options) error {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	want := make(map[string]bool)
+	for _, name := range only {
+		want[filepath.Clean(name)] = true
+	}
+
+	var (
+		buf    bytes.Buffer
+		region []string
+		start  int
+	)
+	lines := strings.SplitAfter(string(src), "\n")
+	for i, l := range lines {
+		if region == nil {
+			buf.WriteString(l)
+			args, ok := marker(l, "gd:begin")
+			if !ok {
+				continue
+			}
+			if len(args) == 0 {
+				return fmt.Errorf("%s:%d: missing source name in gd:begin", path, i+1)
+			}
+			region = args
+			start = i
+			continue
+		}
+
+		if _, ok := marker(l, "gd:end"); !ok {
+			continue
+		}
+		name := region[0]
+		if len(want) != 0 && !want[filepath.Clean(name)] {
+			for _, l := range lines[start+1 : i+1] {
+				buf.WriteString(l)
+			}
+			region = nil
+			continue
+		}
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		opts, err := opts.withConfig(name)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, start+1, err)
+		}
+		doc, err := load(name, region[1:], opts)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, start+1, err)
+		}
+		err = opts.render(&buf, doc, path)
+		if err != nil {
+			return err
+		}
+		if !opts.inline {
+			err = writeImages(dir, doc)
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteString(l)
+		region = nil
+	}
+	if region != nil {
+		return fmt.Errorf("%s:%d: unterminated gd:begin", path, start+1)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), fi.Mode())
+}
+
+// marker returns the fields following the named marker if the line l
+// is an HTML comment holding the marker.
+func marker(l, name string) (fields []string, ok bool) {
+	l = strings.TrimSpace(l)
+	if !strings.HasPrefix(l, "<!--") || !strings.HasSuffix(l, "-->") {
+		return nil, false
+	}
+	fields = strings.Fields(l[len("<!--") : len(l)-len("-->")])
+	if len(fields) == 0 || fields[0] != name {
+		return nil, false
+	}
+	return fields[1:], true
+}
@@ -0,0 +1,145 @@
+// Copyright ©2020 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// hooks is the set of gd hook packages used by rendered programs.
+var hooks = []string{
+	"github.com/kortschak/gd/fmt",
+	"github.com/kortschak/gd/log",
+	"github.com/kortschak/gd/show",
+	"github.com/kortschak/gd/internal/enc",
+}
+
+// watch renders the gd source at path to target, or to stdout if target
+// is empty, and then polls the source, its declared input files and the
+// hook packages at the given interval, re-rendering when any of them
+// change. Errors during rendering are logged and watching continues.
+func watch(path string, args []string, target string, opts options, interval time.Duration) {
+	hookFiles, err := hookSources()
+	if err != nil {
+		log.Printf("not watching hook packages: %v", err)
+	}
+
+	files := append([]string{path}, hookFiles...)
+	render := func() {
+		doc, err := load(path, args, opts)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		files = append(watchedFiles(doc), hookFiles...)
+
+		var buf bytes.Buffer
+		err = opts.render(&buf, doc, target)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		if target == "" {
+			_, err = io.Copy(os.Stdout, &buf)
+		} else {
+			err = ioutil.WriteFile(target, buf.Bytes(), 0666)
+		}
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		if !opts.inline {
+			err = writeImages(imageBase(target), doc)
+			if err != nil {
+				log.Print(err)
+				return
+			}
+		}
+		if target != "" {
+			log.Printf("rendered %s", target)
+		}
+	}
+
+	render()
+	poll(interval, func() []string { return files }, render)
+}
+
+// poll polls the files returned by the files function at the given
+// interval, calling changed when any of them change. Changes are
+// debounced until the files have been stable for one interval.
+func poll(interval time.Duration, files func() []string, changed func()) {
+	last := snapshot(files())
+	for {
+		time.Sleep(interval)
+		curr := snapshot(files())
+		if curr == last {
+			continue
+		}
+		// Wait for the files to settle.
+		for {
+			last = curr
+			time.Sleep(interval)
+			curr = snapshot(files())
+			if curr == last {
+				break
+			}
+		}
+		changed()
+		last = snapshot(files())
+	}
+}
+
+// watchedFiles returns the source, input and stdin files of the document
+// and the other sources of the package holding a test source.
+func watchedFiles(doc *document) []string {
+	files := []string{doc.path}
+	dir := filepath.Dir(doc.path)
+	for _, in := range doc.inputs {
+		files = append(files, filepath.Join(dir, in))
+	}
+	if doc.stdin != "" {
+		files = append(files, doc.stdin)
+	}
+	if doc.examples != nil {
+		// Errors are reported when the document is run.
+		pkg, _ := doc.packageSources()
+		files = append(files, pkg...)
+	}
+	return files
+}
+
+// hookSources returns the Go source files of the hook packages.
+func hookSources() ([]string, error) {
+	args := append([]string{"list", "-f", `{{$dir := .Dir}}{{range .GoFiles}}{{$dir}}/{{.}}{{"\n"}}{{end}}`, "-tags", "gd"}, hooks...)
+	out, err := exec.Command("go", args...).Output()
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// snapshot returns a summary of the state of the given files
+// that changes when any of the files are modified.
+func snapshot(files []string) string {
+	var buf strings.Builder
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			fmt.Fprintf(&buf, "%s missing\n", f)
+			continue
+		}
+		fmt.Fprintf(&buf, "%s %v %d\n", f, fi.ModTime(), fi.Size())
+	}
+	return buf.String()
+}